@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+)
+
+// Policies for errors found while scanning.
+const (
+	onErrorAbort = "abort" // stop at the first error
+	onErrorSkip  = "skip"  // skip unreadable entries, count them only
+	onErrorWarn  = "warn"  // skip unreadable entries, list them at the end
+)
+
+// Exit codes.
+const (
+	exitError   = 1 // generation failed
+	exitUsage   = 2 // bad options or paths
+	exitPartial = 3 // output written, some entries could not be read
+)
+
+// scanError is an entry that could not be read.
+type scanError struct {
+	err  error
+	path string
+}
+
+// errorLog collects scan errors according to a policy. It is safe for concurrent use.
+type errorLog struct {
+	policy string
+	errs   []scanError
+	mu     sync.Mutex
+}
+
+// add records an error and returns it wrapped when the policy aborts.
+func (l *errorLog) add(path string, err error) error {
+	if l == nil || l.policy == onErrorAbort {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	slog.Debug("scan error", "path", path, "err", err)
+	l.mu.Lock()
+	l.errs = append(l.errs, scanError{path: path, err: err})
+	l.mu.Unlock()
+	return nil
+}
+
+// count returns the number of collected errors.
+func (l *errorLog) count() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.errs)
+}
+
+// summary logs collected errors sorted by path, or only their count with the skip policy.
+func (l *errorLog) summary() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.errs) == 0 {
+		return
+	}
+	if l.policy == onErrorWarn {
+		sort.Slice(l.errs, func(i, j int) bool { return l.errs[i].path < l.errs[j].path })
+		for _, e := range l.errs {
+			slog.Warn("scan error", "path", e.path, "err", e.err)
+		}
+		slog.Warn("scan finished with errors, output may be incomplete", "errors", len(l.errs))
+		return
+	}
+
+	slog.Info("unreadable entries skipped", "errors", len(l.errs))
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestErrorLogPolicies(t *testing.T) {
+	t.Parallel()
+
+	boom := errors.New("boom")
+
+	abort := &errorLog{policy: onErrorAbort}
+	if err := abort.add("a", boom); !errors.Is(err, boom) {
+		t.Fatalf("abort should return the error, got %v", err)
+	}
+
+	for _, policy := range []string{onErrorSkip, onErrorWarn} {
+		l := &errorLog{policy: policy}
+		if err := l.add("b", boom); err != nil {
+			t.Fatalf("%s should continue, got %v", policy, err)
+		}
+		if err := l.add("a", boom); err != nil {
+			t.Fatal(err)
+		}
+		if l.count() != 2 {
+			t.Fatalf("%s: count=%d want 2", policy, l.count())
+		}
+		l.summary()
+		if l.errs[0].path != "a" && policy == onErrorWarn {
+			t.Fatal("summary should sort errors by path")
+		}
+	}
+
+	var nilLog *errorLog
+	if err := nilLog.add("a", boom); err == nil {
+		t.Fatal("nil log should abort")
+	}
+}
+
+func TestWalkerReportsUnreadableDirs(t *testing.T) {
+	t.Parallel()
+
+	missing := filepath.Join(t.TempDir(), "missing")
+	var reported []string
+	wk := &walker{workers: 2, onErr: func(path string, _ error) error {
+		reported = append(reported, path)
+		return nil
+	}}
+	err := wk.walk([]string{missing}, func(_, _ int, _ string, _ bool) error {
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reported) != 1 || reported[0] != missing {
+		t.Fatalf("reported %v want %s", reported, missing)
+	}
+}
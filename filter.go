@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"path"
+	"regexp"
+	"strings"
+	"sync/atomic"
+)
+
+// regexRulePrefix marks a filter pattern as a regular expression.
+const regexRulePrefix = "re:"
+
+// fileRule is a single ordered include or exclude rule.
+type fileRule struct {
+	re       *regexp.Regexp
+	pattern  string       // pattern as given
+	matched  atomic.Int64 // files matched by this rule
+	exclude  bool         // exclude instead of include
+	nameOnly bool         // match the file name instead of the relative path
+}
+
+// fileFilter picks model files by ordered include/exclude rules,
+// the last matching rule wins.
+type fileFilter struct {
+	rules []*fileRule
+}
+
+// add appends a rule. A leading '!' inverts the rule, "re:" marks a regexp.
+// Globs without '/' match the file name, other patterns the path
+// relative to game root.
+func (f *fileFilter) add(pattern string, exclude bool) error {
+	p := strings.TrimSpace(pattern)
+	if strings.HasPrefix(p, "!") {
+		exclude = !exclude
+		p = p[1:]
+	}
+	if p == "" {
+		return fmt.Errorf("empty filter pattern %q", pattern)
+	}
+
+	r := &fileRule{pattern: pattern, exclude: exclude}
+	var err error
+	if strings.HasPrefix(p, regexRulePrefix) {
+		r.re, err = regexp.Compile("(?i)" + strings.TrimPrefix(p, regexRulePrefix))
+	} else {
+		r.nameOnly = !strings.Contains(p, "/")
+		r.re, err = compileGlob(strings.TrimPrefix(p, "/"))
+	}
+	if err != nil {
+		return fmt.Errorf("bad filter pattern %q: %w", pattern, err)
+	}
+
+	f.rules = append(f.rules, r)
+	return nil
+}
+
+// match reports whether a file (relative to game root, with '/') is picked.
+// Only .p3d files are considered. Without rules, or when the first rule
+// excludes, every .p3d file is picked up front; when the first rule
+// includes, nothing is picked up front.
+func (f *fileFilter) match(rel string) bool {
+	name := path.Base(rel)
+	if !strings.EqualFold(path.Ext(name), ".p3d") {
+		return false
+	}
+	picked := len(f.rules) == 0 || f.rules[0].exclude
+
+	for _, r := range f.rules {
+		subject := rel
+		if r.nameOnly {
+			subject = name
+		}
+		if r.re.MatchString(subject) {
+			r.matched.Add(1)
+			picked = !r.exclude
+		}
+	}
+
+	return picked
+}
+
+// clone returns a filter with the same rules and its own match counts.
+func (f *fileFilter) clone() *fileFilter {
+	c := &fileFilter{rules: make([]*fileRule, len(f.rules))}
+	for i, r := range f.rules {
+		c.rules[i] = &fileRule{re: r.re, pattern: r.pattern, exclude: r.exclude, nameOnly: r.nameOnly}
+	}
+
+	return c
+}
+
+// reset clears the match counts.
+func (f *fileFilter) reset() {
+	for _, r := range f.rules {
+		r.matched.Store(0)
+	}
+}
+
+// logReport logs per-rule match counts in rule order.
+func (f *fileFilter) logReport() {
+	for i, r := range f.rules {
+		kind := "include"
+		if r.exclude {
+			kind = "exclude"
+		}
+		slog.Info("filter rule", "n", i+1, "kind", kind, "pattern", r.pattern, "files", r.matched.Load())
+	}
+}
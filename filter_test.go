@@ -0,0 +1,152 @@
+package main
+
+import "testing"
+
+func TestCompileGlob(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		glob string
+		path string
+		want bool
+	}{
+		{"**/*_ruin*.p3d", "dz/structures/ruins/House_Ruin_1.p3d", true},
+		{"**/*_ruin*.p3d", "House_ruin.p3d", true},
+		{"**/proxy/**", "dz/structures/proxy/a.p3d", true},
+		{"**/proxy/**", "dz/proxy", true},
+		{"**/proxy/**", "dz/proxyish/a.p3d", false},
+		{"dz/*/a.p3d", "dz/x/y/a.p3d", false},
+		{"dz/**/a.p3d", "dz/x/y/a.p3d", true},
+		{"*.{p3d,xyz}", "a.XYZ", true},
+		{"[!a]*.p3d", "a.p3d", false},
+		{"?.p3d", "ab.p3d", false},
+		{"résidential/*", "Résidential/дом.p3d", true},
+	}
+
+	for _, tc := range cases {
+		re, err := compileGlob(tc.glob)
+		if err != nil {
+			t.Fatalf("compileGlob(%q): %v", tc.glob, err)
+		}
+		if got := re.MatchString(tc.path); got != tc.want {
+			t.Fatalf("glob %q match %q = %v want %v (re %s)", tc.glob, tc.path, got, tc.want, re)
+		}
+	}
+
+	for _, bad := range []string{"[abc", "{a,b", "a}"} {
+		if _, err := compileGlob(bad); err == nil {
+			t.Fatalf("compileGlob(%q) should fail", bad)
+		}
+	}
+}
+
+func TestCompileGlobNonASCII(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		glob string
+		path string
+		want bool
+	}{
+		{"дом_?.p3d", "Дом_1.p3d", true},
+		{"дом_?.p3d", "Дом_12.p3d", false},
+		{"**/résidential/**", "dz/RÉSIDENTIAL/a.p3d", true},
+		{"**/résidential/**", "dz/residential/a.p3d", false},
+		{"{café,bar}/*.p3d", "Café/x.p3d", true},
+		{"ü.p3d", "ü.p3d", true},
+	}
+
+	for _, tc := range cases {
+		re, err := compileGlob(tc.glob)
+		if err != nil {
+			t.Fatalf("compileGlob(%q): %v", tc.glob, err)
+		}
+		if got := re.MatchString(tc.path); got != tc.want {
+			t.Fatalf("glob %q match %q = %v want %v (re %s)", tc.glob, tc.path, got, tc.want, re)
+		}
+	}
+}
+
+func TestFileFilterLastMatchWins(t *testing.T) {
+	t.Parallel()
+
+	var f fileFilter
+	if !f.match("dz/a/house.p3d") || f.match("dz/a/house.paa") {
+		t.Fatal("without rules only .p3d files should be picked")
+	}
+
+	if err := f.add("!**/proxy/**", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.add("**/proxy/keep_*.p3d", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.add("re:_lod\\d\\.p3d$", true); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		rel  string
+		want bool
+	}{
+		{"dz/a/house.p3d", true},
+		{"dz/a/proxy/door.p3d", false},
+		{"dz/a/proxy/keep_door.p3d", true},
+		{"dz/a/house_LOD1.p3d", false},
+	}
+	for _, tc := range cases {
+		if got := f.match(tc.rel); got != tc.want {
+			t.Fatalf("match(%q)=%v want %v", tc.rel, got, tc.want)
+		}
+	}
+	if got := f.rules[0].matched.Load(); got != 2 {
+		t.Fatalf("rule 1 matched %d want 2", got)
+	}
+}
+
+func TestFileFilterFirstIncludeNarrows(t *testing.T) {
+	t.Parallel()
+
+	var f fileFilter
+	if err := f.add("**/*_ruin*.p3d", false); err != nil {
+		t.Fatal(err)
+	}
+	if f.match("dz/a/house.p3d") || !f.match("dz/a/house_ruin.p3d") {
+		t.Fatal("a leading include should pick only matching files")
+	}
+}
+
+func TestFileFilterIncludeKeepsModelsOnly(t *testing.T) {
+	t.Parallel()
+
+	var f fileFilter
+	if err := f.add("dz/structures/**", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.add("re:_co\\.", false); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		rel  string
+		want bool
+	}{
+		{"dz/structures/house.p3d", true},
+		{"dz/structures/house_co.paa", false},
+		{"dz/structures/data/house.rvmat", false},
+		{"dz/plants/bush.p3d", false},
+	}
+	for _, tc := range cases {
+		if got := f.match(tc.rel); got != tc.want {
+			t.Fatalf("match(%q)=%v want %v", tc.rel, got, tc.want)
+		}
+	}
+
+	// Non-model files are not counted by the rules either.
+	if got := f.rules[0].matched.Load(); got != 1 {
+		t.Fatalf("rule 1 matched %d want 1", got)
+	}
+	if got := f.rules[1].matched.Load(); got != 0 {
+		t.Fatalf("rule 2 matched %d want 0", got)
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/woozymasta/tml-gen/tml"
+)
+
+// generator holds validated options and state kept between runs.
+type generator struct {
+	opt       *Options
+	filter    *fileFilter
+	san       *nameSanitizer
+	theme     *theme
+	cache     *scanCache
+	prev      map[string]Library // libraries written by the previous run, by lowercase name
+	format    tml.Format
+	skipRules []*skipRule
+	scanRoots []string // scan paths under game-root
+	layers    []string // game-root followed by overlay layers
+	jobs      int
+}
+
+// runStats summarizes a generation run.
+type runStats struct {
+	output *outputSummary // files touched when written in place
+	models int
+	groups int
+	errors int // unreadable entries
+}
+
+// run scans all roots, builds libraries and writes them. With inPlace set
+// the output directory is updated in place and only libraries that differ
+// from the previous run are rewritten.
+func (g *generator) run(inPlace bool) (runStats, error) {
+	opt := g.opt
+	progressLine.reset()
+
+	// Walk every scan path in game-root and in each overlay layer.
+	errs := &errorLog{policy: opt.OnError}
+	sc := g.newScanner(errs)
+	roots, err := g.roots()
+	if err != nil {
+		return runStats{}, fmt.Errorf("walk error: %w", err)
+	}
+	walked := make([]string, len(roots))
+	for i, r := range roots {
+		walked[i] = r.path
+	}
+
+	progressLine.setPhase("scan")
+	hits, err := sc.scan(roots)
+	if err != nil {
+		return runStats{}, fmt.Errorf("walk error: %w", err)
+	}
+
+	// Keep one file per relative path, later layers override earlier ones.
+	found, stats, duplicates, collisions := mergeHits(hits, len(g.layers))
+	files := make([]scanHit, 0, len(found))
+	for _, h := range found {
+		files = append(files, h)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].rel < files[j].rel })
+
+	modelPath := func(h scanHit) string {
+		return filepath.Join(g.layers[h.layer], filepath.FromSlash(h.rel))
+	}
+
+	// Inspect models in parallel for placeability and footprint shapes.
+	infos := make([]*P3DInfo, len(files))
+	infoErrs := make([]error, len(files))
+	if opt.PlaceableOnly || opt.ShapeFromModel {
+		progressLine.setPhase("inspect")
+		idx := make(chan int, 1024)
+		var wg sync.WaitGroup
+		for w := g.jobs; w > 0; w-- {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := range idx {
+					infos[i], infoErrs[i] = g.cache.modelInfo(modelPath(files[i]))
+				}
+			}()
+		}
+		for i := range files {
+			idx <- i
+		}
+		close(idx)
+		wg.Wait()
+	}
+
+	// Build directory tree to compute grouping by threshold.
+	tree := newNode("", nil)
+	recs := make([]Rec, 0, len(files))
+	models := make(map[string]*P3DInfo)
+	sources := make(map[string]int, len(files))
+	var skipped []skippedModel
+	for i, h := range files {
+		if infoErrs[i] != nil {
+			// Unreadable models are kept, they may well be placeable.
+			if err := errs.add(modelPath(h), infoErrs[i]); err != nil {
+				return runStats{}, err
+			}
+		} else if infos[i] != nil {
+			if reason := unplaceableReason(infos[i]); opt.PlaceableOnly && reason != "" {
+				skipped = append(skipped, skippedModel{RelPath: h.rel, Reason: reason})
+				continue
+			}
+			models[h.rel] = infos[i]
+		}
+
+		segs := splitSegs(h.rel)
+		if len(segs) == 0 {
+			continue
+		}
+		dirNode := insert(tree, segs[:len(segs)-1])
+		recs = append(recs, Rec{RelPath: h.rel, DirNode: dirNode})
+		sources[h.rel] = h.layer
+	}
+
+	if duplicates > 0 {
+		slog.Warn("models found twice by overlapping scan paths were counted once", "count", duplicates)
+	}
+	for _, c := range collisions {
+		slog.Warn("model paths differ only in case, both are kept", "path", c[0], "other", c[1])
+	}
+	reportSkipped(g.skipRules, opt.SkipReport)
+	g.filter.logReport()
+	reportSkippedModels(skipped, opt.SkippedModels)
+	reportLayers(g.layers, stats, recs, sources, opt.LayerReport)
+
+	if len(recs) == 0 {
+		errs.summary()
+		return runStats{}, errors.New("no .p3d found")
+	}
+
+	// Group files by threshold-based directory nodes.
+	byKey := make(map[string]*Group)
+	for _, r := range recs {
+		grp := pickGroup(r.DirNode, opt.Threshold)
+		key := nodeKey(grp)
+		group := byKey[key]
+		if group == nil {
+			group = &Group{Key: key, Path: nodePath(grp)}
+			byKey[key] = group
+		}
+		group.Files = append(group.Files, r.RelPath)
+	}
+
+	// Sort groups by key.
+	groups := make([]Group, 0, len(byKey))
+	for _, grp := range byKey {
+		sort.Strings(grp.Files)
+		groups = append(groups, *grp)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Key < groups[j].Key })
+
+	// Resolve unique names across all libraries before writing anything.
+	colors, err := newColorAllocator(opt.FallbackColors, opt.MinDeltaE)
+	if err != nil {
+		return runStats{}, err
+	}
+	names := newNameRegistry(g.san)
+	lb := &libraryBuilder{names: names, theme: g.theme, colors: colors, shades: opt.TemplateShades}
+	if opt.ShapeFromModel {
+		lb.models = models
+	}
+	progressLine.setPhase("build")
+	libs := lb.build(groups)
+	if err := g.cache.save(walked); err != nil {
+		slog.Warn("scan cache not saved", "err", err)
+	}
+
+	progressLine.setPhase("write")
+	res := runStats{models: len(recs), groups: len(groups)}
+	if inPlace || opt.Incremental {
+		// Libraries written by an earlier run in this session are ours to remove.
+		prune := opt.Prune || inPlace && !opt.Incremental
+		sum, err := writeIncremental(opt, libs, g.format, g.changed, prune)
+		if err != nil {
+			return runStats{}, err
+		}
+		res.output = &sum
+	} else if err := writeStaged(opt, libs, g.format); err != nil {
+		return runStats{}, err
+	}
+
+	g.prev = make(map[string]Library, len(libs))
+	for _, lib := range libs {
+		g.prev[strings.ToLower(lib.Name)] = lib
+	}
+
+	reportNameChanges(names, opt.NameReport)
+
+	errs.summary()
+	res.errors = errs.count()
+	return res, nil
+}
+
+// newScanner returns a scanner for a single walk, ignore files are read afresh.
+func (g *generator) newScanner(errs *errorLog) *scanner {
+	sc := &scanner{cache: g.cache, errs: errs, filter: g.filter, layers: g.layers, skipRules: g.skipRules, workers: g.jobs, follow: g.opt.FollowLinks}
+	if !g.opt.NoIgnore {
+		for _, l := range g.layers {
+			sc.ignores = append(sc.ignores, newIgnoreTree(l))
+		}
+	}
+
+	return sc
+}
+
+// roots returns the scan paths in game-root and in each overlay layer,
+// leaving out scan paths missing from an overlay layer.
+func (g *generator) roots() ([]scanRoot, error) {
+	var roots []scanRoot
+	for li, layerRoot := range g.layers {
+		for _, gameScanRoot := range g.scanRoots {
+			scanRel, err := filepath.Rel(g.opt.GameRoot, gameScanRoot)
+			if err != nil {
+				return nil, err
+			}
+			path := filepath.Join(layerRoot, scanRel)
+			if li > 0 {
+				if info, err := os.Stat(path); err != nil || !info.IsDir() {
+					continue
+				}
+			}
+			roots = append(roots, scanRoot{path: path, rel: scanRel, layer: li})
+		}
+	}
+
+	return roots, nil
+}
+
+// changed reports whether a library differs from the one written by the
+// previous run. Names are resolved over all libraries on every run, so a
+// library is also rewritten when a rename elsewhere changes its names.
+func (g *generator) changed(lib *Library) bool {
+	prev, ok := g.prev[strings.ToLower(lib.Name)]
+	return !ok || !sameLibrary(&prev, lib)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/woozymasta/tml-gen/tml"
+)
+
+// testGenerator returns a generator scanning dz under opt.GameRoot.
+func testGenerator(t *testing.T, opt *Options, cache *scanCache) *generator {
+	t.Helper()
+
+	san, err := newNameSanitizer("A-Za-z0-9_-", 64, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	th, err := loadTheme("", "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &generator{
+		opt: opt, filter: &fileFilter{}, san: san, theme: th, cache: cache, format: tml.DefaultFormat,
+		scanRoots: []string{filepath.Join(opt.GameRoot, "dz")}, layers: []string{opt.GameRoot}, jobs: 2,
+	}
+}
+
+func TestGeneratorCountsUnreadableModels(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	var b p3dBuilder
+	b.WriteString("MLOD")
+	b.u32(0x101)
+	b.u32(2)
+	b.mlodLod([][3]float32{{0, 0, 0}, {1, 1, 1}}, 1)
+	b.mlodLod([][3]float32{{0, 0, 0}, {1, 1, 1}}, 1e13)
+	dir := filepath.Join(root, "dz", "a")
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "good.p3d"), b.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "bad.p3d"), []byte("junk"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, policy := range []string{onErrorWarn, onErrorAbort} {
+		opt := &Options{
+			GameRoot: root, Out: filepath.Join(t.TempDir(), "out"), Threshold: 1,
+			PlaceableOnly: true, ShapeFromModel: true, OnError: policy,
+			Legend: legendNone, FallbackColors: "oklch", MinDeltaE: 8, TemplateShades: "off",
+		}
+		g := testGenerator(t, opt, nil)
+
+		stats, err := g.run(false)
+		if policy == onErrorAbort {
+			if err == nil {
+				t.Fatal("abort policy kept going past an unreadable model")
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.errors != 1 || stats.models != 2 {
+			t.Fatalf("%s: errors=%d models=%d want 1 and 2", policy, stats.errors, stats.models)
+		}
+	}
+}
+
+func TestGeneratorKeepsCachedModelsAcrossRuns(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	var b p3dBuilder
+	b.WriteString("MLOD")
+	b.u32(0x101)
+	b.u32(2)
+	b.mlodLod([][3]float32{{0, 0, 0}, {1, 1, 1}}, 1)
+	b.mlodLod([][3]float32{{0, 0, 0}, {1, 1, 1}}, 1e13)
+	dir := filepath.Join(root, "dz", "a")
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		t.Fatal(err)
+	}
+	model := filepath.Join(dir, "m.p3d")
+	if err := os.WriteFile(model, b.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	gone := filepath.Join(dir, "gone.p3d")
+	if err := os.WriteFile(gone, b.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	age := func() {
+		old := time.Now().Add(-time.Hour)
+		for _, p := range []string{model, gone, dir, filepath.Dir(dir)} {
+			if err := os.Chtimes(p, old, old); err != nil && !os.IsNotExist(err) {
+				t.Fatal(err)
+			}
+		}
+	}
+	age()
+
+	cachePath := filepath.Join(t.TempDir(), "scan.gob.gz")
+	run := func(placeable bool) *scanCache {
+		c, err := loadScanCache(cachePath, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		opt := &Options{
+			GameRoot: root, Out: filepath.Join(t.TempDir(), "out"), Threshold: 1,
+			PlaceableOnly: placeable, OnError: onErrorWarn,
+			Legend: legendNone, FallbackColors: "oklch", MinDeltaE: 8, TemplateShades: "off",
+		}
+		if _, err := testGenerator(t, opt, c).run(false); err != nil {
+			t.Fatal(err)
+		}
+		c, err = loadScanCache(cachePath, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	if c := run(true); c.models[model] == nil || c.models[gone] == nil {
+		t.Fatal("models read by --placeable-only were not cached")
+	}
+
+	// A run that reads no models keeps their metadata.
+	if c := run(false); c.models[model] == nil || c.models[gone] == nil {
+		t.Fatal("a plain run dropped cached models")
+	}
+
+	// Models removed from disk are dropped.
+	if err := os.Remove(gone); err != nil {
+		t.Fatal(err)
+	}
+	age()
+	if c := run(false); c.models[model] == nil || c.models[gone] != nil {
+		t.Fatal("only the removed model should be dropped")
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// compileGlob compiles a case-insensitive doublestar glob for '/' separated paths.
+// Non-ASCII names match literally and fold case like ASCII ones.
+//
+//	glob   matches
+//	*      any run of characters inside a segment
+//	?      one character inside a segment
+//	**     any number of segments, including none
+//	[a-z]  character class, [!a-z] negates
+//	{a,b}  alternatives
+func compileGlob(glob string) (*regexp.Regexp, error) {
+	expr, err := globToRegexp(glob)
+	if err != nil {
+		return nil, err
+	}
+
+	return regexp.Compile("(?i)^" + expr + "$")
+}
+
+// globToRegexp translates a doublestar glob into a regexp body.
+func globToRegexp(glob string) (string, error) {
+	var b strings.Builder
+	braces := 0
+
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch c {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				atStart := i == 0 || glob[i-1] == '/'
+				i++
+				switch {
+				case atStart && i+1 < len(glob) && glob[i+1] == '/':
+					// "**/" matches zero or more leading segments.
+					b.WriteString(`(?:.*/)?`)
+					i++
+				case atStart && i+1 == len(glob) && i >= 2:
+					// "/**" at the end matches the directory and everything below.
+					s := b.String()
+					b.Reset()
+					b.WriteString(strings.TrimSuffix(s, "/"))
+					b.WriteString(`(?:/.*)?`)
+				default:
+					b.WriteString(`.*`)
+				}
+				continue
+			}
+			b.WriteString(`[^/]*`)
+
+		case '?':
+			b.WriteString(`[^/]`)
+
+		case '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				return "", fmt.Errorf("glob %q: unclosed '['", glob)
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+
+		case '{':
+			braces++
+			b.WriteString(`(?:`)
+
+		case '}':
+			if braces == 0 {
+				return "", fmt.Errorf("glob %q: unexpected '}'", glob)
+			}
+			braces--
+			b.WriteString(`)`)
+
+		case ',':
+			if braces > 0 {
+				b.WriteString(`|`)
+			} else {
+				b.WriteString(`,`)
+			}
+
+		default:
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+
+	if braces > 0 {
+		return "", fmt.Errorf("glob %q: unclosed '{'", glob)
+	}
+
+	return b.String(), nil
+}
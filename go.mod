@@ -2,6 +2,20 @@ module github.com/woozymasta/tml-gen
 
 go 1.25.5
 
-require github.com/jessevdk/go-flags v1.6.1
+require (
+	github.com/jessevdk/go-flags v1.6.1
+	golang.org/x/sys v0.37.0
+	modernc.org/sqlite v1.46.1
+)
 
-require golang.org/x/sys v0.21.0 // indirect
+require (
+	github.com/dustin/go-humanize v1.0.1 // indirect
+	github.com/google/uuid v1.6.0 // indirect
+	github.com/mattn/go-isatty v0.0.20 // indirect
+	github.com/ncruces/go-strftime v1.0.0 // indirect
+	github.com/remyoudompheng/bigfft v0.0.0-20230129092748-24d4a6f8daec // indirect
+	golang.org/x/exp v0.0.0-20251023183803-a4bb9ffd2546 // indirect
+	modernc.org/libc v1.67.6 // indirect
+	modernc.org/mathutil v1.7.1 // indirect
+	modernc.org/memory v1.11.0 // indirect
+)
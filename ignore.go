@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// ignoreFileName is the per-directory ignore file honoured by the walker.
+const ignoreFileName = ".tmlignore"
+
+// ignoreSyntaxError is a malformed pattern in an ignore file. It always
+// stops the run: skipping the file would silently drop all of its rules.
+type ignoreSyntaxError struct {
+	err  error
+	path string
+	line int
+}
+
+// Error implements error.
+func (e *ignoreSyntaxError) Error() string {
+	return fmt.Sprintf("%s:%d: %v", e.path, e.line, e.err)
+}
+
+// Unwrap returns the pattern error.
+func (e *ignoreSyntaxError) Unwrap() error {
+	return e.err
+}
+
+// ignoreRule is a single gitignore-style pattern.
+type ignoreRule struct {
+	re       *regexp.Regexp
+	negate   bool // re-include matched paths
+	dirOnly  bool // pattern ended with '/'
+	anchored bool // pattern is relative to the ignore file directory
+}
+
+// ignoreTree loads .tmlignore files lazily and matches paths against them.
+// It is safe for concurrent use.
+type ignoreTree struct {
+	files map[string][]*ignoreRule // rules by directory relative to root, nil if none
+	root  string                   // game root
+	mu    sync.Mutex
+}
+
+// newIgnoreTree creates an ignore matcher for a game root.
+func newIgnoreTree(root string) *ignoreTree {
+	return &ignoreTree{root: root, files: make(map[string][]*ignoreRule)}
+}
+
+// ignored reports whether a path relative to game root is ignored by
+// .tmlignore files in its parent directories, starting at the scan root.
+// Deeper files take precedence and the last matching rule wins.
+// Unreadable ignore files are skipped and the first such error is returned,
+// a malformed one is returned as *ignoreSyntaxError.
+func (t *ignoreTree) ignored(scanRel string, rel string, dir bool) (bool, error) {
+	if t == nil {
+		return false, nil
+	}
+
+	segs := splitSegs(rel)
+	start := len(splitSegs(scanRel))
+	ignored := false
+	var firstErr error
+	for i := start; i < len(segs); i++ {
+		rules, err := t.load(strings.Join(segs[:i], "/"))
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+
+		sub := strings.Join(segs[i:], "/")
+		name := segs[len(segs)-1]
+		for _, r := range rules {
+			if r.dirOnly && !dir {
+				continue
+			}
+			subject := sub
+			if !r.anchored {
+				subject = name
+			}
+			if r.re.MatchString(subject) {
+				ignored = !r.negate
+			}
+		}
+	}
+
+	return ignored, firstErr
+}
+
+// load returns the cached rules of a directory's ignore file.
+func (t *ignoreTree) load(dir string) ([]*ignoreRule, error) {
+	t.mu.Lock()
+	rules, ok := t.files[dir]
+	t.mu.Unlock()
+	if ok {
+		return rules, nil
+	}
+
+	path := filepath.Join(t.root, filepath.FromSlash(dir), ignoreFileName)
+	f, err := os.Open(path) // #nosec G304 -- ignore file inside the scanned tree
+	if errors.Is(err, fs.ErrNotExist) {
+		t.store(dir, nil)
+		return nil, nil
+	}
+	if err != nil {
+		// Report a broken ignore file once and go on without it.
+		t.store(dir, nil)
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	rules, err = parseIgnore(f)
+	if err != nil {
+		t.store(dir, nil)
+		if se, ok := err.(*ignoreSyntaxError); ok {
+			se.path = path
+			return nil, se
+		}
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	t.store(dir, rules)
+
+	return rules, nil
+}
+
+// store caches the rules of a directory.
+func (t *ignoreTree) store(dir string, rules []*ignoreRule) {
+	t.mu.Lock()
+	t.files[dir] = rules
+	t.mu.Unlock()
+}
+
+// parseIgnore parses gitignore-style patterns.
+func parseIgnore(r io.Reader) ([]*ignoreRule, error) {
+	var rules []*ignoreRule
+	sc := bufio.NewScanner(r)
+	for line := 1; sc.Scan(); line++ {
+		rule, err := parseIgnoreLine(sc.Text())
+		if err != nil {
+			return nil, &ignoreSyntaxError{line: line, err: err}
+		}
+		if rule != nil {
+			rules = append(rules, rule)
+		}
+	}
+
+	return rules, sc.Err()
+}
+
+// parseIgnoreLine parses a single pattern, returning nil for blanks and comments.
+func parseIgnoreLine(s string) (*ignoreRule, error) {
+	s = strings.TrimPrefix(s, "\ufeff")
+	s = strings.TrimRight(s, "\r")
+
+	// Trailing spaces are ignored unless escaped.
+	trimmed := strings.TrimRight(s, " ")
+	if strings.HasSuffix(trimmed, `\`) && len(trimmed) < len(s) {
+		trimmed += " "
+	}
+	s = trimmed
+	if s == "" || strings.HasPrefix(s, "#") {
+		return nil, nil
+	}
+
+	rule := &ignoreRule{}
+	switch {
+	case strings.HasPrefix(s, "!"):
+		rule.negate = true
+		s = s[1:]
+	case strings.HasPrefix(s, `\!`), strings.HasPrefix(s, `\#`):
+		s = s[1:]
+	}
+	s = strings.ReplaceAll(s, `\ `, " ")
+
+	if strings.HasSuffix(s, "/") {
+		rule.dirOnly = true
+		s = strings.TrimRight(s, "/")
+	}
+	if strings.Contains(s, "/") {
+		rule.anchored = true
+		s = strings.TrimPrefix(s, "/")
+	}
+	if s == "" {
+		return nil, nil
+	}
+
+	re, err := compileGlob(s)
+	if err != nil {
+		return nil, err
+	}
+	rule.re = re
+
+	return rule, nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseIgnore(t *testing.T) {
+	t.Parallel()
+
+	rules, err := parseIgnore(strings.NewReader("# comment\n\nproxy/\n!keep.p3d\n/test_*\n\\#hash\na/**/b  \n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 5 {
+		t.Fatalf("got %d rules want 5", len(rules))
+	}
+	if !rules[0].dirOnly || rules[0].anchored {
+		t.Fatalf("proxy/ should be an unanchored dir rule: %+v", rules[0])
+	}
+	if !rules[1].negate {
+		t.Fatal("!keep.p3d should negate")
+	}
+	if !rules[2].anchored || !rules[2].re.MatchString("test_house.p3d") {
+		t.Fatal("/test_* should be anchored")
+	}
+	if !rules[3].re.MatchString("#hash") {
+		t.Fatal(`\#hash should match a literal '#'`)
+	}
+	if !rules[4].re.MatchString("a/x/y/b") {
+		t.Fatal("a/**/b should match nested dirs")
+	}
+}
+
+func TestIgnoreTree(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	write := func(rel, content string) {
+		p := filepath.Join(root, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(p), 0o750); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("dz/.tmlignore", "proxy/\n*_test.p3d\n/wip\n")
+	write("dz/a/.tmlignore", "!keep_test.p3d\n")
+
+	tree := newIgnoreTree(root)
+	cases := []struct {
+		rel  string
+		dir  bool
+		want bool
+	}{
+		{"dz/a/proxy", true, true},
+		{"dz/a/proxy", false, false},
+		{"dz/a/house_test.p3d", false, true},
+		{"dz/a/keep_test.p3d", false, false},
+		{"dz/b/keep_test.p3d", false, true},
+		{"dz/wip", true, true},
+		{"dz/a/wip", true, false},
+		{"dz/a/house.p3d", false, false},
+	}
+	for _, tc := range cases {
+		got, err := tree.ignored("dz", tc.rel, tc.dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tc.want {
+			t.Fatalf("ignored(%q, dir=%v) = %v want %v", tc.rel, tc.dir, got, tc.want)
+		}
+	}
+
+	// Ignore files above the scan root do not apply.
+	if got, _ := tree.ignored("dz/a", "dz/a/house_test.p3d", false); got {
+		t.Fatal("ignore file above the scan root should not apply")
+	}
+}
+
+func TestMalformedIgnoreFileStopsScan(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	dir := filepath.Join(root, "dz", "a")
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "dz", ignoreFileName), []byte("proxy/\n[abc\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "m.p3d"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Even the skip policy must not drop the rules of a malformed file.
+	sc := &scanner{
+		errs: &errorLog{policy: onErrorSkip}, filter: &fileFilter{}, layers: []string{root},
+		ignores: []*ignoreTree{newIgnoreTree(root)}, workers: 2,
+	}
+	_, err := sc.scan([]scanRoot{{path: filepath.Join(root, "dz"), rel: "dz"}})
+	var se *ignoreSyntaxError
+	if !errors.As(err, &se) || se.line != 2 || se.path != filepath.Join(root, "dz", ignoreFileName) {
+		t.Fatalf("err=%v want a syntax error on line 2", err)
+	}
+}
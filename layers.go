@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// scanHit is a model file found while walking a layer.
+type scanHit struct {
+	rel   string // relative to the layer root, with '/'
+	layer int    // index into the layer roots, 0 is game-root
+}
+
+// resolveLayers returns game-root followed by the overlay layer roots.
+// Each layer mirrors the game-root layout, later layers override earlier ones.
+func resolveLayers(gameRoot string, layers []string) ([]string, error) {
+	roots := []string{gameRoot}
+	for _, l := range layers {
+		l = strings.TrimSpace(l)
+		if l == "" {
+			continue
+		}
+
+		abs := cleanAbs(l)
+		if info, err := os.Stat(abs); err != nil || !info.IsDir() {
+			return nil, fmt.Errorf("bad layer: %s", abs)
+		}
+		for _, r := range roots {
+			if startsWithPathPrefix(abs, r) || startsWithPathPrefix(r, abs) {
+				return nil, fmt.Errorf("layer %s overlaps %s", abs, r)
+			}
+		}
+
+		roots = append(roots, abs)
+	}
+
+	return roots, nil
+}
+
+// layerStats counts models supplied and overridden per layer.
+type layerStats struct {
+	models     []int // winning models per layer
+	overridden []int // models of earlier layers replaced by this layer
+}
+
+// mergeHits keeps one hit per relative path, later layers win.
+// A layer overrides earlier layers case-insensitively, like the game does,
+// while paths differing only in case within the winning layer are all kept
+// and returned as case collisions. Repeated hits of the same path from the
+// same layer are counted as duplicates.
+func mergeHits(hits []scanHit, layers int) (map[string]scanHit, layerStats, int, [][2]string) {
+	stats := layerStats{models: make([]int, layers), overridden: make([]int, layers)}
+
+	// Unique (path, layer) pairs and the top layer of each folded path.
+	type pathLayer struct {
+		rel   string
+		layer int
+	}
+	seen := make(map[pathLayer]struct{}, len(hits))
+	top := make(map[string]int, len(hits))
+	duplicates := 0
+	for _, h := range hits {
+		k := pathLayer{h.rel, h.layer}
+		if _, ok := seen[k]; ok {
+			duplicates++
+			continue
+		}
+		seen[k] = struct{}{}
+		fold := strings.ToLower(h.rel)
+		if l, ok := top[fold]; !ok || h.layer > l {
+			top[fold] = h.layer
+		}
+	}
+
+	out := make(map[string]scanHit, len(top))
+	variants := make(map[string][]string, len(top)) // kept paths by folded path
+	for k := range seen {
+		fold := strings.ToLower(k.rel)
+		if l := top[fold]; k.layer < l {
+			stats.overridden[l]++
+			continue
+		}
+		out[k.rel] = scanHit{rel: k.rel, layer: k.layer}
+		stats.models[k.layer]++
+		variants[fold] = append(variants[fold], k.rel)
+	}
+
+	var collisions [][2]string
+	for _, v := range variants {
+		sort.Strings(v)
+		for _, other := range v[1:] {
+			collisions = append(collisions, [2]string{v[0], other})
+		}
+	}
+	sort.Slice(collisions, func(i, j int) bool {
+		if collisions[i][0] != collisions[j][0] {
+			return collisions[i][0] < collisions[j][0]
+		}
+		return collisions[i][1] < collisions[j][1]
+	})
+
+	return out, stats, duplicates, collisions
+}
+
+// writeLayerReport writes the root that supplied each model as TSV.
+func writeLayerReport(w io.Writer, recs []Rec, roots []string, sources map[string]int) error {
+	if _, err := fmt.Fprintln(w, "path\troot"); err != nil {
+		return err
+	}
+	for _, r := range recs {
+		if _, err := fmt.Fprintf(w, "%s\t%s\n", r.RelPath, roots[sources[r.RelPath]]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMergeHitsLaterLayerWins(t *testing.T) {
+	t.Parallel()
+
+	hits := []scanHit{
+		{rel: "dz/a/house.p3d", layer: 0},
+		{rel: "dz/a/shed.p3d", layer: 0},
+		{rel: "dz/a/House.p3d", layer: 2},
+		{rel: "dz/a/house.p3d", layer: 1},
+		{rel: "dz/a/shed.p3d", layer: 0},
+		{rel: "dz/b/new.p3d", layer: 1},
+	}
+
+	found, stats, duplicates, collisions := mergeHits(hits, 3)
+	if duplicates != 1 || len(collisions) != 0 {
+		t.Fatalf("duplicates=%d collisions=%v want 1 and none", duplicates, collisions)
+	}
+	if _, ok := found["dz/a/house.p3d"]; ok {
+		t.Fatal("overridden house kept")
+	}
+	if h := found["dz/a/House.p3d"]; h.layer != 2 {
+		t.Fatalf("house from %+v want layer 2", h)
+	}
+	if got := stats.models; got[0] != 1 || got[1] != 1 || got[2] != 1 {
+		t.Fatalf("models per layer %v", got)
+	}
+	if got := stats.overridden; got[0] != 0 || got[1] != 0 || got[2] != 2 {
+		t.Fatalf("overrides per layer %v", got)
+	}
+}
+
+func TestMergeHitsCaseCollisions(t *testing.T) {
+	t.Parallel()
+
+	hits := []scanHit{
+		{rel: "dz/a/wall.p3d", layer: 1},
+		{rel: "dz/a/Wall.p3d", layer: 1},
+		{rel: "dz/a/WALL.p3d", layer: 0},
+		{rel: "dz/a/wall.p3d", layer: 1},
+	}
+
+	found, stats, duplicates, collisions := mergeHits(hits, 2)
+	if duplicates != 1 {
+		t.Fatalf("duplicates=%d want 1", duplicates)
+	}
+	if len(found) != 2 || found["dz/a/wall.p3d"].layer != 1 || found["dz/a/Wall.p3d"].layer != 1 {
+		t.Fatalf("found %v", found)
+	}
+	if len(collisions) != 1 || collisions[0] != [2]string{"dz/a/Wall.p3d", "dz/a/wall.p3d"} {
+		t.Fatalf("collisions %v", collisions)
+	}
+	if stats.overridden[1] != 1 || stats.models[1] != 2 {
+		t.Fatalf("stats %+v", stats)
+	}
+}
+
+func TestResolveLayers(t *testing.T) {
+	t.Parallel()
+
+	game, mod := t.TempDir(), t.TempDir()
+	roots, err := resolveLayers(game, []string{mod})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(roots) != 2 || roots[1] != mod {
+		t.Fatalf("roots %v", roots)
+	}
+
+	if _, err := resolveLayers(game, []string{game + "/dz"}); err == nil {
+		t.Fatal("layer inside game-root should fail")
+	}
+}
+
+func TestWriteLayerReport(t *testing.T) {
+	t.Parallel()
+
+	var b strings.Builder
+	recs := []Rec{{RelPath: "dz/a.p3d"}, {RelPath: "dz/b.p3d"}}
+	err := writeLayerReport(&b, recs, []string{"/game", "/mod"}, map[string]int{"dz/b.p3d": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "path\troot\ndz/a.p3d\t/game\ndz/b.p3d\t/mod\n"
+	if b.String() != want {
+		t.Fatalf("report %q want %q", b.String(), want)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"html/template"
+	"io"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Legend formats.
+const (
+	legendNone = "none"
+	legendHTML = "html"
+	legendSVG  = "svg"
+)
+
+// legendEntry is a library row in the legend.
+type legendEntry struct {
+	Name      string
+	Shape     string
+	Fill      string   // CSS color
+	Outline   string   // CSS color, empty when the outline is not set
+	Dirs      []string // source directories relative to game root
+	Templates int
+}
+
+// legendTemplate renders a self-contained HTML legend.
+var legendTemplate = template.Must(template.New("legend").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>tml-gen legend</title>
+<style>
+body { font-family: sans-serif; margin: 1.5em; color: #222; }
+table { border-collapse: collapse; }
+th, td { padding: 4px 10px; border-bottom: 1px solid #ddd; text-align: left; vertical-align: top; }
+td.count { text-align: right; }
+svg { display: block; }
+summary { cursor: pointer; }
+ul { margin: 4px 0; padding-left: 1.2em; font-family: monospace; }
+</style>
+</head>
+<body>
+<h1>Template libraries</h1>
+<p>{{len .}} libraries</p>
+<table>
+<thead><tr><th>Swatch</th><th>Library</th><th>Shape</th><th>Templates</th><th>Source directories</th></tr></thead>
+<tbody>
+{{- range .}}
+<tr>
+<td><svg width="40" height="24" viewBox="0 0 40 24">
+{{- if eq .Shape "ellipse"}}<ellipse cx="20" cy="12" rx="18" ry="10"{{else}}<rect x="2" y="2" width="36" height="20"{{end}} fill="{{.Fill}}" stroke="{{if .Outline}}{{.Outline}}{{else}}none{{end}}" stroke-width="2"/>
+</svg></td>
+<td>{{.Name}}</td>
+<td>{{.Shape}}</td>
+<td class="count">{{.Templates}}</td>
+<td>{{if eq (len .Dirs) 1}}<ul><li>{{index .Dirs 0}}</li></ul>{{else}}<details><summary>{{len .Dirs}} directories</summary><ul>{{range .Dirs}}<li>{{.}}</li>{{end}}</ul></details>{{end}}</td>
+</tr>
+{{- end}}
+</tbody>
+</table>
+</body>
+</html>
+`))
+
+// legendEntries builds legend rows in library order.
+func legendEntries(libs []Library) []legendEntry {
+	out := make([]legendEntry, 0, len(libs))
+	for i := range libs {
+		lib := &libs[i]
+		e := legendEntry{
+			Name:      lib.Name,
+			Shape:     lib.Shape,
+			Fill:      cssColor(lib.Fill),
+			Templates: len(lib.Templates),
+			Dirs:      libraryDirs(lib),
+		}
+		if lib.Outline != defaultOutline {
+			e.Outline = cssColor(lib.Outline)
+		}
+		out = append(out, e)
+	}
+
+	return out
+}
+
+// libraryDirs returns the sorted unique source directories of a library.
+func libraryDirs(lib *Library) []string {
+	seen := make(map[string]struct{})
+	dirs := make([]string, 0, 4)
+	for _, t := range lib.Templates {
+		d := path.Dir(strings.ReplaceAll(t.File, `\`, "/"))
+		if _, ok := seen[d]; ok {
+			continue
+		}
+		seen[d] = struct{}{}
+		dirs = append(dirs, d)
+	}
+	sort.Strings(dirs)
+
+	return dirs
+}
+
+// cssColor converts an ARGB color to a CSS rgba() value.
+func cssColor(c int) string {
+	a, r, g, b := argbChannels(c)
+	return fmt.Sprintf("rgba(%d,%d,%d,%.3g)", r, g, b, float64(a)/255)
+}
+
+// writeLegend writes the library legend in the given format.
+func writeLegend(w io.Writer, format string, libs []Library) error {
+	entries := legendEntries(libs)
+	switch format {
+	case legendHTML:
+		return legendTemplate.Execute(w, entries)
+	case legendSVG:
+		return writeLegendSVG(w, entries)
+	default:
+		return fmt.Errorf("unknown legend format %q", format)
+	}
+}
+
+// writeLegendSVG writes the legend as a standalone SVG image.
+func writeLegendSVG(w io.Writer, entries []legendEntry) error {
+	const rowHeight = 28
+
+	bw := bufio.NewWriter(w)
+	height := 40 + len(entries)*rowHeight
+	fmt.Fprintf(bw, `<?xml version="1.0" encoding="UTF-8"?>`+"\n")
+	fmt.Fprintf(bw, `<svg xmlns="http://www.w3.org/2000/svg" width="900" height="%d" font-family="sans-serif" font-size="13">`+"\n", height)
+	fmt.Fprintf(bw, `<rect width="100%%" height="100%%" fill="#fff"/>`+"\n")
+	fmt.Fprintf(bw, `<text x="10" y="24" font-size="16" font-weight="bold">Template libraries (%d)</text>`+"\n", len(entries))
+
+	for i, e := range entries {
+		y := 40 + i*rowHeight
+		stroke := e.Outline
+		if stroke == "" {
+			stroke = "none"
+		}
+		if e.Shape == "ellipse" {
+			fmt.Fprintf(bw, `<ellipse cx="30" cy="%d" rx="18" ry="10" fill="%s" stroke="%s" stroke-width="2"/>`+"\n", y+12, e.Fill, stroke)
+		} else {
+			fmt.Fprintf(bw, `<rect x="12" y="%d" width="36" height="20" fill="%s" stroke="%s" stroke-width="2"/>`+"\n", y+2, e.Fill, stroke)
+		}
+
+		dirs := strings.Join(e.Dirs, ", ")
+		if len(e.Dirs) > 3 {
+			dirs = fmt.Sprintf("%s, ... (%d directories)", strings.Join(e.Dirs[:3], ", "), len(e.Dirs))
+		}
+		fmt.Fprintf(bw, `<text x="60" y="%d">%s</text>`+"\n", y+17, html.EscapeString(e.Name))
+		fmt.Fprintf(bw, `<text x="340" y="%d">%s</text>`+"\n", y+17, e.Shape)
+		fmt.Fprintf(bw, `<text x="440" y="%d" text-anchor="end">%d</text>`+"\n", y+17, e.Templates)
+		fmt.Fprintf(bw, `<text x="460" y="%d" fill="#555">%s</text>`+"\n", y+17, html.EscapeString(dirs))
+	}
+
+	fmt.Fprintf(bw, "</svg>\n")
+	return bw.Flush()
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/woozymasta/tml-gen/tml"
+)
+
+// legendLibs returns libraries in generation order, not sorted by name.
+func legendLibs() []Library {
+	return []Library{
+		{Library: tml.Library{Name: "zeta", Shape: "ellipse", Fill: rgb(0, 128, 0), Outline: defaultOutline, Templates: []tml.Template{
+			{File: `dz\plants\tree\b.p3d`}, {File: `dz\plants\bush\a.p3d`}, {File: `dz\plants\tree\c.p3d`},
+		}}},
+		{Library: tml.Library{Name: "<alpha & co>", Shape: "rectangle", Fill: rgb(255, 0, 0), Outline: rgb(0, 0, 255), Templates: []tml.Template{
+			{File: `dz\walls\w.p3d`},
+		}}},
+	}
+}
+
+func TestLegendEntries(t *testing.T) {
+	t.Parallel()
+
+	entries := legendEntries(legendLibs())
+	if len(entries) != 2 || entries[0].Name != "zeta" || entries[1].Name != "<alpha & co>" {
+		t.Fatalf("entries not in library order: %+v", entries)
+	}
+
+	e := entries[0]
+	if e.Fill != cssColor(rgb(0, 128, 0)) || e.Outline != "" || e.Templates != 3 {
+		t.Fatalf("unexpected entry %+v", e)
+	}
+	if want := []string{"dz/plants/bush", "dz/plants/tree"}; !slices.Equal(e.Dirs, want) {
+		t.Fatalf("dirs %v want %v", e.Dirs, want)
+	}
+	if got := entries[1].Outline; got != cssColor(rgb(0, 0, 255)) {
+		t.Fatalf("outline %q", got)
+	}
+}
+
+func TestCSSColor(t *testing.T) {
+	t.Parallel()
+
+	if got := cssColor(rgb(255, 128, 0)); got != "rgba(255,128,0,1)" {
+		t.Fatalf("got %q", got)
+	}
+	if got := cssColor(0x80000000); got != "rgba(0,0,0,0.502)" {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestWriteLegend(t *testing.T) {
+	t.Parallel()
+
+	for _, format := range []string{legendHTML, legendSVG} {
+		var buf bytes.Buffer
+		if err := writeLegend(&buf, format, legendLibs()); err != nil {
+			t.Fatal(err)
+		}
+		out := buf.String()
+
+		if strings.Contains(out, "<alpha") || !strings.Contains(out, "&lt;alpha &amp; co&gt;") {
+			t.Fatalf("%s: library name not escaped", format)
+		}
+		if i, j := strings.Index(out, "zeta"), strings.Index(out, "&lt;alpha"); i < 0 || j < i {
+			t.Fatalf("%s: rows not in library order", format)
+		}
+		ellipse := strings.Index(out, `<ellipse`)
+		rect := strings.LastIndex(out, `<rect x=`)
+		if ellipse < 0 || rect < ellipse {
+			t.Fatalf("%s: swatch shapes do not follow libraries", format)
+		}
+		for _, swatch := range []string{`fill="` + cssColor(rgb(0, 128, 0)) + `" stroke="none"`, `fill="` + cssColor(rgb(255, 0, 0)) + `" stroke="` + cssColor(rgb(0, 0, 255)) + `"`} {
+			if !strings.Contains(out, swatch) {
+				t.Fatalf("%s: missing swatch %s", format, swatch)
+			}
+		}
+	}
+
+	if err := writeLegend(&bytes.Buffer{}, "pdf", nil); err == nil {
+		t.Fatal("unknown format accepted")
+	}
+}
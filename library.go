@@ -0,0 +1,213 @@
+package main
+
+import (
+	"log/slog"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/woozymasta/tml-gen/tml"
+)
+
+// Template shade modes.
+const (
+	shadesOff  = "off"  // every template uses the library colors
+	shadesDir  = "dir"  // shade by model subdirectory inside the group
+	shadesName = "name" // shade by model name
+)
+
+// Library is a resolved template library ready to be written.
+// Templates are sorted by model path, names are sanitized and global-unique.
+type Library struct {
+	Key   string // logical group key (original casing)
+	Group string // group node path relative to game root, with '/'
+	tml.Library
+}
+
+// sameLibrary reports whether two libraries render the same.
+func sameLibrary(a, b *Library) bool {
+	return a.Key == b.Key && a.Name == b.Name && a.Group == b.Group && a.Shape == b.Shape &&
+		a.Tex == b.Tex && a.Fill == b.Fill && a.Outline == b.Outline && slices.Equal(a.Templates, b.Templates)
+}
+
+// Group is a set of model files picked into one library by threshold.
+type Group struct {
+	Key   string   // node key, path segments joined with '_'
+	Path  string   // node path relative to game root, with '/'
+	Files []string // model paths relative to game root, with '/'
+}
+
+// Footprint thresholds for model-based template shapes.
+const (
+	footprintMaxRoundAspect = 2.5 // longer footprints are always rectangles
+	footprintMinRoundness   = 0.9 // share of points inside the inscribed ellipse
+)
+
+// libraryBuilder resolves groups into libraries.
+type libraryBuilder struct {
+	names  *nameRegistry   // name normalization and uniqueness
+	theme  *theme          // themed colors and shapes
+	colors *colorAllocator // fallback colors
+	shades string          // template shade mode
+
+	// Model metadata by relative path for footprint shapes, read up front
+	// in parallel; nil disables them, missing models keep the library shape.
+	models map[string]*P3DInfo
+}
+
+// build resolves names, colors and shapes for groups sorted by key.
+func (lb *libraryBuilder) build(groups []Group) []Library {
+	// Color is derived from the (possibly mixed-case) logical library name.
+	// Themed colors are reserved first so fallback colors keep away from them.
+	type style struct{ fill, outline int }
+	styles := make([]style, len(groups))
+	themed := make([]bool, len(groups))
+	for i, g := range groups {
+		fill, outline, ok := lb.theme.colors(g.Key, g.Path)
+		if ok {
+			styles[i] = style{fill, outline}
+			themed[i] = true
+			lb.colors.reserve(fill)
+		}
+	}
+	for i, g := range groups {
+		if !themed[i] {
+			styles[i] = style{lb.colors.fallback(g.Key), defaultOutline}
+		}
+	}
+
+	libs := make([]Library, 0, len(groups))
+	for i, g := range groups {
+		fill, outline := styles[i].fill, styles[i].outline
+		shape := lb.theme.shape(g.Key, g.Path)
+		explicitShape := shape != "" && lb.theme.explicit
+		if shape == "" {
+			shape = "rectangle"
+		}
+
+		tex, libUV := lb.theme.icon(g.Key, g.Path)
+		lib := Library{
+			Key:   g.Key,
+			Group: g.Path,
+			Library: tml.Library{
+				Name:      lb.names.libraryName(g.Key),
+				Shape:     shape,
+				Tex:       tex,
+				Fill:      fill,
+				Outline:   outline,
+				Templates: make([]tml.Template, 0, len(g.Files)),
+			},
+		}
+
+		shapes := make([]string, 0, len(g.Files))
+		for _, rel := range g.Files {
+			segs := splitSegs(rel)
+			if len(segs) == 0 {
+				continue
+			}
+			fileName := segs[len(segs)-1]
+			base := strings.TrimSuffix(fileName, filepath.Ext(fileName))
+
+			lib.Templates = append(lib.Templates, tml.Template{
+				Name:    lb.names.templateName(base, rel),
+				File:    toBackslashes(strings.Join(segs, "/")),
+				Fill:    lb.templateFill(fill, g.Path, rel, base),
+				Outline: outline,
+				Hash:    tml.Hash(base),
+				UV:      lb.theme.templateUV(rel, tex, libUV),
+			})
+
+			tShape := ""
+			if !explicitShape {
+				tShape = lb.templateShape(rel)
+			}
+			shapes = append(shapes, tShape)
+		}
+
+		libs = append(libs, lb.splitByShape(lib, shapes)...)
+	}
+
+	return libs
+}
+
+// templateShape decides a template shape from the model footprint, or "".
+func (lb *libraryBuilder) templateShape(rel string) string {
+	return footprintShape(lb.models[rel])
+}
+
+// splitByShape moves templates whose shape differs from the library shape
+// into a sibling library, as TerrainBuilder keeps the shape per library.
+func (lb *libraryBuilder) splitByShape(lib Library, shapes []string) []Library {
+	var moved []tml.Template
+	kept := make([]tml.Template, 0, len(lib.Templates))
+	other := ""
+	for i, t := range lib.Templates {
+		if shapes[i] != "" && shapes[i] != lib.Shape {
+			moved = append(moved, t)
+			other = shapes[i]
+			continue
+		}
+		kept = append(kept, t)
+	}
+
+	switch {
+	case len(moved) == 0:
+		return []Library{lib}
+	case len(kept) == 0:
+		lib.Shape = other
+		return []Library{lib}
+	}
+
+	sibling := lib
+	sibling.Key = lib.Key + "_" + other
+	sibling.Name = lb.names.libraryName(sibling.Key)
+	sibling.Shape = other
+	sibling.Templates = moved
+	lib.Templates = kept
+	slog.Info("library split by model shape", "library", lib.Name, "sibling", sibling.Name, "templates", len(moved))
+
+	return []Library{lib, sibling}
+}
+
+// footprintShape picks "ellipse" or "rectangle" from the model X/Z footprint,
+// or "" when the model data is not enough to decide.
+func footprintShape(info *P3DInfo) string {
+	if info == nil || !info.HasBBox {
+		return ""
+	}
+
+	dx := float64(info.BBoxMax[0] - info.BBoxMin[0])
+	dz := float64(info.BBoxMax[2] - info.BBoxMin[2])
+	if dx <= 0 || dz <= 0 {
+		return ""
+	}
+
+	if max(dx, dz)/min(dx, dz) >= footprintMaxRoundAspect {
+		return "rectangle"
+	}
+	switch {
+	case info.Roundness < 0:
+		return ""
+	case info.Roundness >= footprintMinRoundness:
+		return "ellipse"
+	default:
+		return "rectangle"
+	}
+}
+
+// templateFill derives a per-template shade of the library fill.
+func (lb *libraryBuilder) templateFill(fill int, groupPath string, rel string, base string) int {
+	switch lb.shades {
+	case shadesDir:
+		dir := path.Dir(rel)
+		if groupPath == "" || !strings.HasPrefix(dir, groupPath+"/") {
+			return fill
+		}
+		return shadeColor(fill, strings.TrimPrefix(dir, groupPath+"/"))
+	case shadesName:
+		return shadeColor(fill, base)
+	default:
+		return fill
+	}
+}
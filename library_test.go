@@ -0,0 +1,86 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/woozymasta/tml-gen/tml"
+)
+
+// shapeBuilder returns a library builder with the given theme and models.
+func shapeBuilder(t *testing.T, th *theme, models map[string]*P3DInfo) *libraryBuilder {
+	t.Helper()
+
+	san, err := newNameSanitizer("A-Za-z0-9_-", 64, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	colors, err := newColorAllocator(paletteOKLCH, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &libraryBuilder{names: newNameRegistry(san), theme: th, colors: colors, shades: shadesOff, models: models}
+}
+
+func TestSplitByShape(t *testing.T) {
+	t.Parallel()
+
+	lb := shapeBuilder(t, defaultTheme, nil)
+	lib := Library{Key: "dz_farm", Library: tml.Library{Name: "dz_farm", Shape: "rectangle", Fill: 1, Outline: 2, Templates: []tml.Template{
+		{Name: "shed"}, {Name: "silo"}, {Name: "barn"},
+	}}}
+
+	libs := lb.splitByShape(lib, []string{"", "ellipse", "rectangle"})
+	if len(libs) != 2 {
+		t.Fatalf("got %d libraries want 2", len(libs))
+	}
+	kept, sibling := libs[0], libs[1]
+	if kept.Shape != "rectangle" || len(kept.Templates) != 2 || kept.Templates[1].Name != "barn" {
+		t.Fatalf("kept %+v", kept)
+	}
+	if sibling.Key != "dz_farm_ellipse" || sibling.Name != "dz_farm_ellipse" || sibling.Shape != "ellipse" ||
+		sibling.Fill != 1 || sibling.Outline != 2 || len(sibling.Templates) != 1 || sibling.Templates[0].Name != "silo" {
+		t.Fatalf("sibling %+v", sibling)
+	}
+
+	// Without a kept template the library itself changes shape.
+	libs = lb.splitByShape(lib, []string{"ellipse", "ellipse", "ellipse"})
+	if len(libs) != 1 || libs[0].Shape != "ellipse" || libs[0].Name != "dz_farm" {
+		t.Fatalf("all moved: %+v", libs)
+	}
+	if libs = lb.splitByShape(lib, []string{"", "", "rectangle"}); len(libs) != 1 || libs[0].Shape != "rectangle" {
+		t.Fatalf("none moved: %+v", libs)
+	}
+}
+
+func TestBuildShapesFromModels(t *testing.T) {
+	t.Parallel()
+
+	round := &P3DInfo{HasBBox: true, BBoxMin: [3]float32{-3, 0, -3}, BBoxMax: [3]float32{3, 9, 3}, Roundness: 0.95}
+	models := map[string]*P3DInfo{"dz/farm/silo.p3d": round}
+	groups := []Group{{Key: "dz_farm", Path: "dz/farm", Files: []string{"dz/farm/shed.p3d", "dz/farm/silo.p3d"}}}
+
+	libs := shapeBuilder(t, defaultTheme, models).build(groups)
+	if len(libs) != 2 || libs[0].Shape != "rectangle" || libs[1].Name != "dz_farm_ellipse" || libs[1].Templates[0].Name != "silo" {
+		t.Fatalf("built-in theme: %+v", libs)
+	}
+
+	// A shape set by a theme file is explicit and wins over the footprints.
+	file := filepath.Join(t.TempDir(), "theme.json")
+	spec := `{"themes":{"mine":{"rules":[{"pattern":"dz/farm","shape":"rectangle"}]}}}`
+	if err := os.WriteFile(file, []byte(spec), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	th, err := loadTheme(file, "mine")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !th.explicit {
+		t.Fatal("theme from a file is not explicit")
+	}
+	libs = shapeBuilder(t, th, models).build(groups)
+	if len(libs) != 1 || libs[0].Shape != "rectangle" || len(libs[0].Templates) != 2 {
+		t.Fatalf("explicit theme: %+v", libs)
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// progressInterval is how often the progress line is redrawn.
+const progressInterval = 200 * time.Millisecond
+
+// progressLine is the live progress line on a terminal, nil when disabled.
+var progressLine *progress
+
+// setupLogging installs the default slog logger writing to stderr.
+// A progress line is drawn when stderr is a terminal and the output is text.
+func setupLogging(level string, format string, quiet bool) error {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("bad log level %q", level)
+	}
+	if quiet {
+		lvl = slog.LevelError
+	}
+
+	var w io.Writer = os.Stderr
+	if !quiet && format == "text" && isTerminal(os.Stderr) {
+		progressLine = newProgress(os.Stderr)
+		w = progressLine
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+	var h slog.Handler
+	switch format {
+	case "text":
+		h = slog.NewTextHandler(w, opts)
+	case "json":
+		h = slog.NewJSONHandler(w, opts)
+	default:
+		return fmt.Errorf("bad log format %q", format)
+	}
+	slog.SetDefault(slog.New(h))
+
+	return nil
+}
+
+// isTerminal reports whether f is a character device.
+func isTerminal(f *os.File) bool {
+	st, err := f.Stat()
+	return err == nil && st.Mode()&os.ModeCharDevice != 0
+}
+
+// fatal logs an error and exits with code.
+func fatal(code int, msg string, args ...any) {
+	progressLine.stop()
+	slog.Error(msg, args...)
+	os.Exit(code)
+}
+
+// progress draws a single status line and keeps log records above it.
+type progress struct {
+	w      io.Writer
+	done   chan struct{}
+	phase  atomic.Value // string
+	dirs   atomic.Int64
+	models atomic.Int64
+	mu     sync.Mutex
+	width  int  // length of the drawn line
+	closed bool // stop was called
+}
+
+// newProgress starts redrawing a progress line on w.
+func newProgress(w io.Writer) *progress {
+	p := &progress{w: w, done: make(chan struct{})}
+	p.phase.Store("start")
+
+	go func() {
+		t := time.NewTicker(progressInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				p.mu.Lock()
+				p.draw()
+				p.mu.Unlock()
+			case <-p.done:
+				return
+			}
+		}
+	}()
+
+	return p
+}
+
+// Write writes a log record above the progress line.
+func (p *progress) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.clear()
+	n, err := p.w.Write(b)
+	p.draw()
+	return n, err
+}
+
+// setPhase switches the phase shown in the progress line.
+func (p *progress) setPhase(name string) {
+	slog.Debug("phase", "name", name)
+	if p != nil {
+		p.phase.Store(name)
+	}
+}
+
+// addDir counts a visited directory.
+func (p *progress) addDir() {
+	if p != nil {
+		p.dirs.Add(1)
+	}
+}
+
+// addModel counts a found model.
+func (p *progress) addModel() {
+	if p != nil {
+		p.models.Add(1)
+	}
+}
+
+// reset clears the counters before another run.
+func (p *progress) reset() {
+	if p != nil {
+		p.dirs.Store(0)
+		p.models.Store(0)
+	}
+}
+
+// stop removes the progress line for good.
+func (p *progress) stop() {
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return
+	}
+	p.closed = true
+	close(p.done)
+	p.clear()
+}
+
+// draw writes the progress line, the caller holds mu.
+func (p *progress) draw() {
+	if p.closed {
+		return
+	}
+
+	line := fmt.Sprintf("%s: %d dirs, %d models", p.phase.Load(), p.dirs.Load(), p.models.Load())
+	pad := ""
+	if len(line) < p.width {
+		pad = strings.Repeat(" ", p.width-len(line))
+	}
+	_, _ = fmt.Fprint(p.w, "\r"+line+pad)
+	p.width = len(line)
+}
+
+// clear erases the progress line, the caller holds mu.
+func (p *progress) clear() {
+	if p.width == 0 {
+		return
+	}
+	_, _ = fmt.Fprint(p.w, "\r"+strings.Repeat(" ", p.width)+"\r")
+	p.width = 0
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestProgressKeepsLogsAboveLine(t *testing.T) {
+	t.Parallel()
+
+	var b bytes.Buffer
+	p := newProgress(&b)
+	p.setPhase("scan")
+	p.addDir()
+	p.addModel()
+	p.addModel()
+
+	if _, err := p.Write([]byte("level=INFO msg=test\n")); err != nil {
+		t.Fatal(err)
+	}
+	p.stop()
+	p.stop()
+
+	out := b.String()
+	if !strings.HasPrefix(out, "level=INFO msg=test\n") {
+		t.Fatalf("log record should come first: %q", out)
+	}
+	if !strings.Contains(out, "\rscan: 1 dirs, 2 models") {
+		t.Fatalf("progress line missing: %q", out)
+	}
+	if !strings.HasSuffix(out, "\r") {
+		t.Fatalf("progress line should be cleared on stop: %q", out)
+	}
+
+	// A nil progress is a no-op.
+	var np *progress
+	np.addDir()
+	np.setPhase("x")
+	np.stop()
+}
+
+func TestSetupLoggingRejectsBadLevel(t *testing.T) {
+	t.Parallel()
+
+	if err := setupLogging("loud", "text", false); err == nil {
+		t.Fatal("bad level should fail")
+	}
+}
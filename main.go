@@ -1,32 +1,90 @@
 package main
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
-	"io/fs"
+	"io"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"sort"
 	"strings"
-	"sync"
+	"time"
 
 	"github.com/jessevdk/go-flags"
+	"github.com/woozymasta/tml-gen/tml"
 )
 
 // Options defines CLI arguments.
 type Options struct {
-	GameRoot  string   `short:"g" long:"game-root" required:"true" description:"Game root directory (absolute)"`
-	Out       string   `short:"o" long:"out" default:"out" description:"Output dir"`
-	Paths     []string `short:"p" long:"path" required:"true" description:"Path to scan: relative to game-root OR absolute inside game-root (repeatable)"`
-	Skip      []string `short:"s" long:"skip" default:"animals" default:"characters" default:"data" default:"gear" default:"vehicles" default:"weapons" description:"Skip path prefixes (repeatable)"`
-	Threshold int      `short:"n" long:"threshold" default:"75" description:"Min objects per library"`
-	Force     bool     `short:"f" long:"force" description:"Delete output directory before writing"`
-	Version   bool     `short:"v" long:"version" description:"Show version"`
+	GameRoot    string   `short:"g" long:"game-root" required:"true" description:"Game root directory (absolute)"`
+	Out         string   `short:"o" long:"out" default:"out" description:"Output dir"`
+	Paths       []string `short:"p" long:"path" required:"true" description:"Path to scan: relative to game-root OR absolute inside game-root (repeatable)"`
+	Skip        []string `short:"s" long:"skip" default:"animals" default:"characters" default:"data" default:"gear" default:"vehicles" default:"weapons" description:"Skip paths by whole segments, prefix* or glob (repeatable)"`
+	SkipReport  string   `long:"skip-report" description:"Write paths skipped by each --skip rule to a TSV file"`
+	NoIgnore    bool     `long:"no-ignore-files" description:"Do not honour .tmlignore files under the scan paths"`
+	FollowLinks bool     `long:"follow-links" description:"Follow symlinks and junctions, skipping links back to their own ancestors"`
+
+	Layers      []string `long:"layer" description:"Overlay root mirroring the game-root layout, later layers override earlier ones for the same <File> (repeatable)"`
+	LayerReport string   `long:"layer-report" description:"Write the root that supplied each model to a TSV file"`
+
+	Jobs         int    `short:"j" long:"jobs" default:"0" description:"Parallel workers for scanning and reading models (0 = number of CPUs)"`
+	CacheFile    string `long:"cache-file" description:"Scan cache file (default: per game-root file in the user cache dir)"`
+	NoCache      bool   `long:"no-cache" description:"Do not read or write the scan cache"`
+	RebuildCache bool   `long:"rebuild-cache" description:"Ignore the existing scan cache and write a fresh one"`
+
+	PlaceableOnly bool   `long:"placeable-only" description:"Read every model and exclude proxies, helpers and other models without resolution and geometry LODs"`
+	SkippedModels string `long:"skipped-models" description:"Write models excluded by --placeable-only to a TSV file instead of stderr"`
+	Threshold     int    `short:"n" long:"threshold" default:"75" description:"Min objects per library"`
+	Force         string `short:"f" long:"force" optional:"yes" optional-value:"all" choice:"all" choice:"tml-only" description:"Replace a non-empty output directory; tml-only replaces only .tml files"`
+	Incremental   bool   `short:"i" long:"incremental" description:"Update the output in place, rewriting only changed files"`
+	Prune         bool   `long:"prune" description:"Delete stale .tml files in incremental mode (default: list them)"`
+	Backups       int    `long:"backups" default:"1" description:"Number of rotating out.bak.N backups of the previous output to keep (0 = none)"`
+	OnError       string `long:"on-error" default:"warn" choice:"abort" choice:"skip" choice:"warn" description:"On unreadable entries: abort, skip them, or skip and list them at the end with exit code 3"`
+	Version       bool   `short:"v" long:"version" description:"Show version"`
+
+	LogLevel  string `long:"log-level" default:"info" choice:"debug" choice:"info" choice:"warn" choice:"error" description:"Log level"`
+	LogFormat string `long:"log-format" default:"text" choice:"text" choice:"json" description:"Log format on stderr"`
+	Quiet     bool   `short:"q" long:"quiet" description:"Log only errors and hide the progress line and summary"`
+
+	ThemeFile string `long:"theme-file" description:"JSON theme file with named color themes"`
+	Theme     string `long:"theme" default:"default" description:"Theme name to use from built-ins or --theme-file"`
+	DumpTheme bool   `long:"dump-theme" description:"Print built-in themes as a theme file and exit"`
+
+	FallbackColors string   `long:"fallback-colors" default:"oklch" choice:"oklch" choice:"cb-safe" choice:"legacy" description:"Colors for libraries not matched by the theme"`
+	MinDeltaE      float64  `long:"min-delta-e" default:"8" description:"Min OKLab distance (x100) between fallback and other library colors"`
+	Manifest       []string `long:"manifest" choice:"json" choice:"csv" choice:"sqlite" description:"Write a manifest.<format> catalogue of all models into the output dir (repeatable)"`
+	Legend         string   `long:"legend" default:"none" choice:"none" choice:"html" choice:"svg" description:"Write a legend.html or legend.svg color key into the output dir"`
+	ShapeFromModel bool     `long:"shape-from-model" description:"Pick template shapes from the p3d footprint: long models become rectangles; round ones become ellipses for editable (MLOD) models only, binarized (ODOL) ones keep the library shape"`
+	TemplateShades string   `long:"template-shades" default:"off" choice:"off" choice:"dir" choice:"name" description:"Vary template fill lightness by model subdirectory or name"`
+
+	NameCharset string `long:"name-charset" default:"A-Za-z0-9_-" description:"Allowed ASCII characters in <Name> and library names (ranges like a-z)"`
+	NameMaxLen  int    `long:"name-max-len" default:"64" description:"Max length of <Name> and library names (0 = unlimited)"`
+	NoTranslit  bool   `long:"no-translit" description:"Replace non-ASCII characters instead of transliterating them"`
+	NameReport  string `long:"name-report" description:"Write renamed names to a TSV file instead of stderr"`
+
+	EOL         string `long:"eol" default:"lf" choice:"lf" choice:"crlf" description:"Line endings of .tml files"`
+	Indent      string `long:"indent" default:"tab" description:"Indentation of .tml files: tab or a number of spaces"`
+	XMLEncoding string `long:"xml-encoding" description:"Encoding declared in the .tml XML prolog, only UTF-8 is supported"`
+	BOM         bool   `long:"bom" description:"Write a UTF-8 byte order mark to .tml files"`
+
+	Include func(string) error `long:"include" value-name:"PATTERN" description:"Include files matching a glob or re:regexp (repeatable, last match wins)"`
+	Exclude func(string) error `long:"exclude" value-name:"PATTERN" description:"Exclude files matching a glob or re:regexp (repeatable, last match wins)"`
+
+	Watch WatchCommand `command:"watch" description:"Generate libraries, then regenerate the changed ones whenever models change"`
 }
 
 func main() {
 	var opt Options
+	var filter fileFilter
+	opt.Include = func(s string) error { return filter.add(s, false) }
+	opt.Exclude = func(s string) error { return filter.add(s, true) }
+
 	p := flags.NewParser(&opt, flags.Default|flags.PassDoubleDash)
+	p.SubcommandsOptional = true
 	p.ShortDescription = "Template Library generator for TerrainBuilder (DayZ/Arma 3)."
 	p.LongDescription = `Generates *.tml Template Libraries by scanning P:/ (or any game root).
 Designed to automate DayZ/Arma 3 map setup and keep libraries easy to refresh.
@@ -35,8 +93,16 @@ Key behavior:
 - Groups files by directory nodes with --threshold, but never bubbles up to the top-level group.
 - Keeps <File> paths exactly as scanned (relative to game-root, original casing).
 - Ensures global-unique <Name> across all libraries; only modifies on duplicates.
-- Supports --skip prefix rules (relative to scan-root or game-root) to exclude subtrees.
-- Auto colors and shapes libraries based on their type; unknown types use a hash color.`
+- Normalizes <Name> and library names to a safe charset and max length.
+- Supports --skip segment, prefix* and glob rules (relative to scan-root or game-root) to exclude subtrees.
+- Honours gitignore-style .tmlignore files under the scan paths.
+- Supports ordered --include/--exclude glob and regexp rules for model files.
+- Supports overlay --layer roots where later layers override the same <File>.
+- Caches directory listings and model metadata between runs (--no-cache, --rebuild-cache).
+- Optionally excludes proxies and helper models by inspecting p3d LODs (--placeable-only).
+- The watch command regenerates changed libraries whenever models change.
+- Auto colors and shapes libraries based on their type; unknown types use a distinct hash color.
+- Colors and shapes can be customized with a --theme-file.`
 
 	_, err := p.Parse()
 
@@ -45,6 +111,14 @@ Key behavior:
 		os.Exit(0)
 	}
 
+	if opt.DumpTheme {
+		if err := dumpThemes(os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(exitError)
+		}
+		os.Exit(0)
+	}
+
 	if err != nil {
 		if err == flags.ErrHelp {
 			os.Exit(0)
@@ -52,35 +126,63 @@ Key behavior:
 		if fe, ok := err.(*flags.Error); ok && fe.Type == flags.ErrHelp {
 			os.Exit(0)
 		}
-		os.Exit(2)
+		os.Exit(exitUsage)
 	}
 
+	if err := setupLogging(opt.LogLevel, opt.LogFormat, opt.Quiet); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitUsage)
+	}
+	defer progressLine.stop()
+
 	if opt.Threshold <= 0 {
-		fmt.Fprintln(os.Stderr, "threshold must be > 0")
-		os.Exit(2)
+		fatal(exitUsage, "threshold must be > 0")
+	}
+	if slices.Contains(opt.Manifest, manifestSQLite) && !sqliteSupported {
+		fatal(exitUsage, "--manifest sqlite is not built in (rebuild with -tags sqlite)")
+	}
+	if p.Active != nil && (opt.Watch.Debounce < 0 || opt.Watch.PollInterval <= 0) {
+		fatal(exitUsage, "debounce must be >= 0 and poll-interval > 0")
+	}
+
+	san, err := newNameSanitizer(opt.NameCharset, opt.NameMaxLen, !opt.NoTranslit)
+	if err != nil {
+		fatal(exitUsage, "invalid option", "err", err)
+	}
+
+	format, err := parseTMLFormat(opt.EOL, opt.Indent, opt.XMLEncoding, opt.BOM)
+	if err != nil {
+		fatal(exitUsage, "invalid option", "err", err)
+	}
+
+	th, err := loadTheme(opt.ThemeFile, opt.Theme)
+	if err != nil {
+		fatal(exitUsage, "invalid option", "err", err)
+	}
+
+	if _, err := newColorAllocator(opt.FallbackColors, opt.MinDeltaE); err != nil {
+		fatal(exitUsage, "invalid option", "err", err)
 	}
 
 	// Normalize important paths upfront.
 	opt.GameRoot = cleanAbs(opt.GameRoot)
 	opt.Out = cleanAbs(opt.Out)
 
-	// Prepare output directory (create or clean).
-	prepareOut(opt.Out, opt.Force)
+	if opt.Backups < 0 {
+		fatal(exitUsage, "backups must be >= 0")
+	}
 
 	if opt.GameRoot == "" {
-		fmt.Fprintln(os.Stderr, "game-root is required")
-		os.Exit(2)
+		fatal(exitUsage, "game-root is required")
 	}
 	if info, err := os.Stat(opt.GameRoot); err != nil || !info.IsDir() {
-		fmt.Fprintf(os.Stderr, "bad game-root: %s\n", opt.GameRoot)
-		os.Exit(2)
+		fatal(exitUsage, "bad game-root", "path", opt.GameRoot)
 	}
 
-	// Build normalized skip prefixes for fast matching during traversal.
-	skipPrefixes, err := buildSkipPrefixes(opt.GameRoot, opt.Skip)
+	// Build normalized skip rules for fast matching during traversal.
+	skipRules, err := buildSkipRules(opt.GameRoot, opt.Skip)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		os.Exit(2)
+		fatal(exitUsage, "invalid option", "err", err)
 	}
 
 	// Normalize scan roots to absolute under game-root
@@ -94,148 +196,272 @@ Key behavior:
 		if filepath.IsAbs(p) {
 			abs = cleanAbs(p)
 			if !startsWithPathPrefix(abs, opt.GameRoot) {
-				fmt.Fprintf(os.Stderr, "path not under game-root: %s\n", p)
-				os.Exit(2)
+				fatal(exitUsage, "path not under game-root", "path", p)
 			}
 		} else {
 			abs = cleanAbs(filepath.Join(opt.GameRoot, p))
 		}
 		if info, err := os.Stat(abs); err != nil || !info.IsDir() {
-			fmt.Fprintf(os.Stderr, "bad scan path: %s\n", abs)
-			os.Exit(2)
+			fatal(exitUsage, "bad scan path", "path", abs)
 		}
 		scanRoots = append(scanRoots, abs)
 	}
 	if len(scanRoots) == 0 {
-		fmt.Fprintln(os.Stderr, "no valid --path provided")
-		os.Exit(2)
-	}
-
-	// Build directory tree to compute grouping by threshold.
-	tree := newNode("", nil)
-
-	type item struct{ rel string }
-	ch := make(chan item, 8192)
-
-	var mu sync.Mutex
-	recs := make([]Rec, 0, 20000)
-
-	workers := runtime.GOMAXPROCS(0)
-	var wg sync.WaitGroup
-	wg.Add(workers)
-	for i := 0; i < workers; i++ {
-		go func() {
-			defer wg.Done()
-			for it := range ch {
-				// Insert each file path into the tree and record its node.
-				segs := splitSegs(it.rel)
-				if len(segs) == 0 {
-					continue
-				}
-
-				dirSegs := segs[:len(segs)-1]
-				mu.Lock()
-				dirNode := insert(tree, dirSegs)
-				recs = append(recs, Rec{RelPath: filepath.ToSlash(it.rel), DirNode: dirNode})
-				mu.Unlock()
-			}
-		}()
+		fatal(exitUsage, "no valid --path provided")
 	}
 
-	for _, scanRoot := range scanRoots {
-		if err := filepath.WalkDir(scanRoot, func(path string, d fs.DirEntry, err error) error {
-			if err != nil {
-				if d != nil && d.IsDir() {
-					return fs.SkipDir
-				}
-				return err
-			}
+	// Merge nested and repeated scan roots so no model is visited twice.
+	scanRoots, merged := mergeScanRoots(scanRoots)
+	for _, note := range merged {
+		slog.Warn(note)
+	}
 
-			// Compute both relative paths: to game-root and to the scan-root.
-			relGame, err := filepath.Rel(opt.GameRoot, path)
-			if err != nil {
-				if d.IsDir() {
-					return fs.SkipDir
-				}
-				return nil
-			}
+	// Game-root is the base layer, overlay layers follow in priority order.
+	layers, err := resolveLayers(opt.GameRoot, opt.Layers)
+	if err != nil {
+		fatal(exitUsage, "invalid option", "err", err)
+	}
 
-			relScan, err := filepath.Rel(scanRoot, path)
-			if err != nil {
-				if d.IsDir() {
-					return fs.SkipDir
-				}
-				return nil
-			}
+	// Check the output directory; it is replaced only after generation succeeds.
+	prepareOut(opt.Out, opt.Force, opt.Incremental, opt.GameRoot, append(slices.Clone(scanRoots), layers[1:]...))
 
-			relGameTrimmed := ""
-			if segs := splitSegs(relGame); len(segs) > 1 {
-				relGameTrimmed = strings.Join(segs[1:], "/")
+	// Reuse directory listings and model metadata from earlier runs.
+	var cache *scanCache
+	if !opt.NoCache {
+		cachePath := opt.CacheFile
+		if cachePath == "" {
+			if cachePath, err = defaultScanCachePath(opt.GameRoot); err != nil {
+				slog.Warn("scan cache disabled", "err", err)
 			}
-			if matchSkip(relScan, skipPrefixes) || matchSkip(relGame, skipPrefixes) || matchSkip(relGameTrimmed, skipPrefixes) {
-				if d.IsDir() {
-					return fs.SkipDir
-				}
-				return nil
+		}
+		if cachePath != "" {
+			if cache, err = loadScanCache(cachePath, opt.RebuildCache); err != nil {
+				slog.Warn("scan cache ignored", "err", err)
 			}
+		}
+	}
 
-			// Only enqueue .p3d files.
-			if d.IsDir() {
-				return nil
-			}
-			if !strings.EqualFold(filepath.Ext(d.Name()), ".p3d") {
-				return nil
+	jobs := opt.Jobs
+	if jobs <= 0 {
+		jobs = runtime.GOMAXPROCS(0)
+	}
+	g := &generator{
+		opt:       &opt,
+		filter:    &filter,
+		san:       san,
+		theme:     th,
+		cache:     cache,
+		format:    format,
+		skipRules: skipRules,
+		scanRoots: scanRoots,
+		layers:    layers,
+		jobs:      jobs,
+	}
+
+	stats, err := g.run(false)
+	if p.Active != nil {
+		if err != nil {
+			slog.Error("generation failed", "err", err)
+		} else {
+			logRun(stats)
+		}
+		if err := g.watch(&opt.Watch); err != nil {
+			fatal(exitError, "watch failed", "err", err)
+		}
+		return
+	}
+	var se *ignoreSyntaxError
+	if errors.As(err, &se) {
+		fatal(exitUsage, "bad ignore file", "err", se)
+	}
+	if err != nil {
+		fatal(exitError, "generation failed", "err", err)
+	}
+
+	progressLine.stop()
+	if !opt.Quiet {
+		if sum := stats.output; sum != nil {
+			fmt.Printf("created=%d updated=%d unchanged=%d removed=%d stale=%d\n",
+				sum.Created, sum.Updated, sum.Unchanged, sum.Removed, len(sum.Stale))
+		}
+		fmt.Printf("game_root=%s p3d=%d groups=%d threshold=%d out=%s\n", opt.GameRoot, stats.models, stats.groups, opt.Threshold, opt.Out)
+	}
+
+	// Output was written, but some entries could not be read.
+	if opt.OnError == onErrorWarn && stats.errors > 0 {
+		os.Exit(exitPartial)
+	}
+}
+
+// reportNameChanges writes renamed names to a report file or to stderr.
+func reportNameChanges(names *nameRegistry, reportPath string) {
+	if len(names.changes) == 0 {
+		return
+	}
+
+	if reportPath == "" {
+		for _, c := range names.changes {
+			slog.Warn("name changed", "kind", c.Kind, "from", c.From, "to", c.To, "source", c.Source)
+		}
+		return
+	}
+
+	if err := writeFileAtomic(reportPath, names.writeReport); err != nil {
+		fatal(exitError, "name report error", "err", err)
+	}
+	slog.Warn("names changed", "count", len(names.changes), "report", reportPath)
+}
+
+// reportSkipped prints per-rule skip counts and optionally writes the skipped paths.
+func reportSkipped(rules []*skipRule, reportPath string) {
+	for _, r := range rules {
+		if len(r.skipped) == 0 {
+			continue
+		}
+		dirs := 0
+		for _, p := range r.skipped {
+			if strings.HasSuffix(p, "/") {
+				dirs++
 			}
+		}
+		slog.Info("skip rule", "pattern", r.pattern, "dirs", dirs, "files", len(r.skipped)-dirs)
+	}
+
+	if reportPath == "" {
+		return
+	}
+	if err := writeFileAtomic(reportPath, func(w io.Writer) error {
+		return writeSkipReport(w, rules)
+	}); err != nil {
+		fatal(exitError, "skip report error", "err", err)
+	}
+}
 
-			ch <- item{rel: filepath.ToSlash(relGame)}
-			return nil
-		}); err != nil {
-			fmt.Fprintln(os.Stderr, "walk error:", err)
-			os.Exit(1)
+// reportLayers prints per-layer model counts and optionally writes the source of each model.
+func reportLayers(layers []string, stats layerStats, recs []Rec, sources map[string]int, reportPath string) {
+	if len(layers) > 1 {
+		for i, root := range layers {
+			slog.Info("layer", "root", root, "models", stats.models[i], "overrides", stats.overridden[i])
 		}
 	}
 
-	close(ch)
-	wg.Wait()
+	if reportPath == "" {
+		return
+	}
+	if err := writeFileAtomic(reportPath, func(w io.Writer) error {
+		return writeLayerReport(w, recs, layers, sources)
+	}); err != nil {
+		fatal(exitError, "layer report error", "err", err)
+	}
+}
 
-	if len(recs) == 0 {
-		fmt.Fprintln(os.Stderr, "no .p3d found")
-		os.Exit(1)
+// reportSkippedModels writes non-placeable models to a report file or to stderr.
+func reportSkippedModels(skipped []skippedModel, reportPath string) {
+	if len(skipped) == 0 {
+		return
 	}
+	sort.Slice(skipped, func(i, j int) bool { return skipped[i].RelPath < skipped[j].RelPath })
 
-	// Group files by threshold-based directory nodes.
-	groups := make(map[string][]string)
-	for _, r := range recs {
-		g := pickGroup(r.DirNode, opt.Threshold)
-		key := nodeKey(g)
-		groups[key] = append(groups[key], r.RelPath)
+	if reportPath == "" {
+		for _, m := range skipped {
+			slog.Warn("model skipped", "path", m.RelPath, "reason", m.Reason)
+		}
+		return
 	}
 
-	// Sort groups by key.
-	keys := make([]string, 0, len(groups))
-	for k := range groups {
-		keys = append(keys, k)
+	if err := writeFileAtomic(reportPath, func(w io.Writer) error {
+		return writeSkippedModels(w, skipped)
+	}); err != nil {
+		fatal(exitError, "skipped models report error", "err", err)
 	}
-	sort.Strings(keys)
+	slog.Warn("models skipped", "count", len(skipped), "report", reportPath)
+}
 
-	// Track unique names across all libraries.
-	usedNames := make(map[string]struct{}, 4096)
-	for _, k := range keys {
-		lst := groups[k]
-		sort.Strings(lst)
+// writeStaged writes everything into a staging dir and swaps it in only on success.
+func writeStaged(opt *Options, libs []Library, format tml.Format) error {
+	stage, err := beginOutput(opt.Out, opt.Backups)
+	if err != nil {
+		return fmt.Errorf("staging out error: %w", err)
+	}
+	if err := writeOutputs(newOutputSink(stage.dir, false), opt, libs, format, nil); err != nil {
+		stage.abort()
+		return err
+	}
+
+	commit := stage.commit
+	if opt.Force == forceTMLOnly {
+		commit = stage.commitTMLOnly
+	}
+	if err := commit(); err != nil {
+		stage.abort()
+		return fmt.Errorf("commit out error: %w", err)
+	}
+
+	return nil
+}
+
+// writeIncremental updates the output dir in place, skipping unchanged files
+// and libraries for which changed returns false.
+func writeIncremental(opt *Options, libs []Library, format tml.Format, changed func(lib *Library) bool, prune bool) (outputSummary, error) {
+	if err := os.MkdirAll(opt.Out, 0o750); err != nil {
+		return outputSummary{}, fmt.Errorf("mkdir out error: %w", err)
+	}
+
+	sink := newOutputSink(opt.Out, true)
+	if err := writeOutputs(sink, opt, libs, format, changed); err != nil {
+		return outputSummary{}, err
+	}
+	if err := sink.pruneStale(prune); err != nil {
+		return outputSummary{}, fmt.Errorf("prune error: %w", err)
+	}
+
+	for _, name := range sink.summary.Stale {
+		slog.Warn("stale library (use --prune)", "name", name)
+	}
+
+	return sink.summary, nil
+}
+
+// writeOutputs writes libraries, manifests and the legend into the sink.
+// Libraries for which changed returns false are kept as they are (nil writes all).
+func writeOutputs(sink *outputSink, opt *Options, libs []Library, format tml.Format, changed func(lib *Library) bool) error {
+	now := time.Now()
+	for i := range libs {
+		lib := &libs[i]
+		if changed != nil && !changed(lib) && sink.keep(lib.Name+".tml") {
+			continue
+		}
+		if err := sink.writeFile(lib.Name+".tml", func(w io.Writer) error {
+			return tml.Write(w, &lib.Library, now, format)
+		}, sameIgnoringDates); err != nil {
+			return fmt.Errorf("write tml error: %w", err)
+		}
+	}
 
-		// Normalize output naming to lowercase for files and library names.
-		libName := strings.ToLower(k)
-		outPath := filepath.Join(opt.Out, libName+".tml")
+	for _, format := range opt.Manifest {
+		name := "manifest." + format
+		var err error
+		if format == manifestSQLite {
+			err = sink.replaceFile(name, func(tmpPath string) error {
+				return fillManifestSQLite(tmpPath, libs)
+			})
+		} else {
+			err = sink.writeFile(name, func(w io.Writer) error {
+				return writeManifest(w, format, libs)
+			}, bytes.Equal)
+		}
+		if err != nil {
+			return fmt.Errorf("manifest error: %w", err)
+		}
+	}
 
-		// Color is derived from the (possibly mixed-case) logical library name.
-		fill, outline := colorForLibrary(k)
-		shape := shapeForLibrary(k)
-		if err := writeTML(outPath, libName, lst, fill, outline, shape, usedNames); err != nil {
-			fmt.Fprintln(os.Stderr, "write tml error:", err)
-			os.Exit(1)
+	if opt.Legend != legendNone {
+		if err := sink.writeFile("legend."+opt.Legend, func(w io.Writer) error {
+			return writeLegend(w, opt.Legend, libs)
+		}, bytes.Equal); err != nil {
+			return fmt.Errorf("legend error: %w", err)
 		}
 	}
 
-	fmt.Printf("game_root=%s p3d=%d groups=%d threshold=%d out=%s\n", opt.GameRoot, len(recs), len(groups), opt.Threshold, opt.Out)
+	return nil
 }
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Manifest formats.
+const (
+	manifestJSON   = "json"
+	manifestCSV    = "csv"
+	manifestSQLite = "sqlite"
+)
+
+// manifestRow describes a single model in the manifest.
+type manifestRow struct {
+	Library     string `json:"library"`      // library name
+	LibraryFile string `json:"library_file"` // library file name
+	Name        string `json:"name"`         // template <Name>
+	File        string `json:"file"`         // template <File>
+	Shape       string `json:"shape"`        // library shape
+	Group       string `json:"group"`        // group node path
+	Fill        int    `json:"fill"`         // template <Fill>
+	Outline     int    `json:"outline"`      // template <Outline>
+	Hash        int32  `json:"hash"`         // template <Hash>
+}
+
+// manifestRows flattens libraries into manifest rows in library order.
+func manifestRows(libs []Library) []manifestRow {
+	n := 0
+	for i := range libs {
+		n += len(libs[i].Templates)
+	}
+
+	rows := make([]manifestRow, 0, n)
+	for i := range libs {
+		lib := &libs[i]
+		for _, t := range lib.Templates {
+			rows = append(rows, manifestRow{
+				Library:     lib.Name,
+				LibraryFile: lib.Name + ".tml",
+				Name:        t.Name,
+				File:        t.File,
+				Shape:       lib.Shape,
+				Group:       lib.Group,
+				Fill:        t.Fill,
+				Outline:     t.Outline,
+				Hash:        t.Hash,
+			})
+		}
+	}
+
+	return rows
+}
+
+// writeManifest writes the model manifest in JSON or CSV.
+func writeManifest(w io.Writer, format string, libs []Library) error {
+	rows := manifestRows(libs)
+	switch format {
+	case manifestJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(rows)
+
+	case manifestCSV:
+		cw := csv.NewWriter(w)
+		_ = cw.Write([]string{"library", "library_file", "name", "file", "hash", "fill", "outline", "shape", "group"})
+		for _, r := range rows {
+			_ = cw.Write([]string{
+				r.Library, r.LibraryFile, r.Name, r.File,
+				strconv.Itoa(int(r.Hash)), strconv.Itoa(r.Fill), strconv.Itoa(r.Outline),
+				r.Shape, r.Group,
+			})
+		}
+		cw.Flush()
+		return cw.Error()
+
+	default:
+		return fmt.Errorf("unknown manifest format %q", format)
+	}
+}
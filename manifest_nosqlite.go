@@ -0,0 +1,13 @@
+//go:build !sqlite
+
+package main
+
+import "errors"
+
+// sqliteSupported reports whether --manifest sqlite is built in.
+const sqliteSupported = false
+
+// fillManifestSQLite is not available without the sqlite build tag.
+func fillManifestSQLite(_ string, _ []Library) error {
+	return errors.New("built without SQLite support (rebuild with -tags sqlite)")
+}
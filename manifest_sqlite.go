@@ -0,0 +1,83 @@
+//go:build sqlite
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+
+	_ "modernc.org/sqlite" // pure Go SQLite driver
+)
+
+// sqliteSupported reports whether --manifest sqlite is built in.
+const sqliteSupported = true
+
+// fillManifestSQLite creates manifest tables in an empty database file.
+// It is written through replaceAtomic, so readers never see a partial database.
+func fillManifestSQLite(path string, libs []Library) error {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = db.Close() }()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.Exec(`
+CREATE TABLE libraries (
+	name      TEXT PRIMARY KEY,
+	file      TEXT NOT NULL,
+	shape     TEXT NOT NULL,
+	fill      INTEGER NOT NULL,
+	outline   INTEGER NOT NULL,
+	tex       TEXT NOT NULL,
+	grp       TEXT NOT NULL,
+	templates INTEGER NOT NULL
+);
+CREATE TABLE models (
+	name    TEXT PRIMARY KEY COLLATE NOCASE,
+	file    TEXT NOT NULL,
+	library TEXT NOT NULL REFERENCES libraries(name),
+	hash    INTEGER NOT NULL,
+	fill    INTEGER NOT NULL,
+	outline INTEGER NOT NULL
+);
+CREATE INDEX models_library ON models(library);
+CREATE VIEW manifest AS
+	SELECT m.library, l.file AS library_file, m.name, m.file, m.hash,
+		m.fill, m.outline, l.shape, l.grp AS "group"
+	FROM models m JOIN libraries l ON l.name = m.library;
+`); err != nil {
+		return err
+	}
+
+	libStmt, err := tx.Prepare(`INSERT INTO libraries VALUES (?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = libStmt.Close() }()
+
+	modelStmt, err := tx.Prepare(`INSERT INTO models VALUES (?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = modelStmt.Close() }()
+
+	for i := range libs {
+		lib := &libs[i]
+		if _, err := libStmt.Exec(lib.Name, lib.Name+".tml", lib.Shape, lib.Fill, lib.Outline, lib.Tex, lib.Group, len(lib.Templates)); err != nil {
+			return err
+		}
+		for _, t := range lib.Templates {
+			if _, err := modelStmt.Exec(t.Name, t.File, lib.Name, t.Hash, t.Fill, t.Outline); err != nil {
+				return fmt.Errorf("model %s: %w", t.File, err)
+			}
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,52 @@
+//go:build sqlite
+
+package main
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+)
+
+func TestFillManifestSQLite(t *testing.T) {
+	t.Parallel()
+
+	libs := manifestLibs(t)
+	path := filepath.Join(t.TempDir(), "manifest.sqlite")
+	if err := fillManifestSQLite(path, libs); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = db.Close() }()
+
+	res, err := db.Query(`SELECT library, library_file, name, file, hash, fill, outline, shape, "group" FROM manifest`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = res.Close() }()
+
+	want := make(map[string]manifestRow)
+	for _, r := range manifestRows(libs) {
+		want[r.Name] = r
+	}
+	i := 0
+	for ; res.Next(); i++ {
+		var r manifestRow
+		if err := res.Scan(&r.Library, &r.LibraryFile, &r.Name, &r.File, &r.Hash, &r.Fill, &r.Outline, &r.Shape, &r.Group); err != nil {
+			t.Fatal(err)
+		}
+		if r != want[r.Name] {
+			t.Fatalf("row %d = %+v", i, r)
+		}
+	}
+	if err := res.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if i != len(want) {
+		t.Fatalf("%d rows want %d", i, len(want))
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"strconv"
+	"testing"
+)
+
+// manifestLibs returns two built libraries sharing a group.
+func manifestLibs(t *testing.T) []Library {
+	t.Helper()
+
+	san, err := newNameSanitizer("A-Za-z0-9_-", 64, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	colors, err := newColorAllocator(paletteOKLCH, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	th, err := loadTheme("", "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lb := &libraryBuilder{names: newNameRegistry(san), theme: th, colors: colors, shades: shadesOff}
+	return lb.build([]Group{
+		{Key: "dz_plants", Path: "dz/plants", Files: []string{"dz/plants/bush/Bush.p3d", "dz/plants/tree/Oak.p3d"}},
+		{Key: "dz_structures", Path: "dz/structures", Files: []string{"dz/structures/Bush.p3d"}},
+	})
+}
+
+func TestManifestRowsMatchLibraries(t *testing.T) {
+	t.Parallel()
+
+	libs := manifestLibs(t)
+	rows := manifestRows(libs)
+
+	i := 0
+	for _, lib := range libs {
+		for _, tpl := range lib.Templates {
+			r := rows[i]
+			want := manifestRow{
+				Library: lib.Name, LibraryFile: lib.Name + ".tml", Name: tpl.Name, File: tpl.File,
+				Shape: lib.Shape, Group: lib.Group, Fill: tpl.Fill, Outline: tpl.Outline, Hash: tpl.Hash,
+			}
+			if r != want {
+				t.Fatalf("row %d = %+v want %+v", i, r, want)
+			}
+			i++
+		}
+	}
+	if i != len(rows) || i != 3 {
+		t.Fatalf("%d rows for %d templates", len(rows), i)
+	}
+
+	// The duplicate model name is renamed in the manifest as in the library.
+	if rows[0].Name == rows[2].Name {
+		t.Fatalf("duplicate names in manifest: %q", rows[0].Name)
+	}
+}
+
+func TestWriteManifest(t *testing.T) {
+	t.Parallel()
+
+	libs := manifestLibs(t)
+	want := manifestRows(libs)
+
+	var js bytes.Buffer
+	if err := writeManifest(&js, manifestJSON, libs); err != nil {
+		t.Fatal(err)
+	}
+	var got []manifestRow
+	if err := json.Unmarshal(js.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("json rows %d want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("json row %d = %+v want %+v", i, got[i], want[i])
+		}
+	}
+
+	var cs bytes.Buffer
+	if err := writeManifest(&cs, manifestCSV, libs); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&cs).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != len(want)+1 || records[0][0] != "library" {
+		t.Fatalf("csv has %d records", len(records))
+	}
+	for i, w := range want {
+		r := records[i+1]
+		fields := []string{w.Library, w.LibraryFile, w.Name, w.File, strconv.Itoa(int(w.Hash)),
+			strconv.Itoa(w.Fill), strconv.Itoa(w.Outline), w.Shape, w.Group}
+		for k := range fields {
+			if r[k] != fields[k] {
+				t.Fatalf("csv row %d field %d = %q want %q", i, k, r[k], fields[k])
+			}
+		}
+	}
+
+	if err := writeManifest(&bytes.Buffer{}, "xml", libs); err == nil {
+		t.Fatal("unknown format accepted")
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// nameReplacement replaces characters outside of the allowed charset.
+const nameReplacement = '_'
+
+// translit maps common non-ASCII runes to ASCII spellings.
+var translit = map[rune]string{
+	// Latin-1 supplement and Latin extended.
+	'À': "A", 'Á': "A", 'Â': "A", 'Ã': "A", 'Ä': "Ae", 'Å': "A", 'Æ': "Ae",
+	'Ç': "C", 'È': "E", 'É': "E", 'Ê': "E", 'Ë': "E", 'Ì': "I", 'Í': "I",
+	'Î': "I", 'Ï': "I", 'Ð': "D", 'Ñ': "N", 'Ò': "O", 'Ó': "O", 'Ô': "O",
+	'Õ': "O", 'Ö': "Oe", 'Ø': "O", 'Ù': "U", 'Ú': "U", 'Û': "U", 'Ü': "Ue",
+	'Ý': "Y", 'Þ': "Th", 'ß': "ss",
+	'à': "a", 'á': "a", 'â': "a", 'ã': "a", 'ä': "ae", 'å': "a", 'æ': "ae",
+	'ç': "c", 'è': "e", 'é': "e", 'ê': "e", 'ë': "e", 'ì': "i", 'í': "i",
+	'î': "i", 'ï': "i", 'ð': "d", 'ñ': "n", 'ò': "o", 'ó': "o", 'ô': "o",
+	'õ': "o", 'ö': "oe", 'ø': "o", 'ù': "u", 'ú': "u", 'û': "u", 'ü': "ue",
+	'ý': "y", 'þ': "th", 'ÿ': "y",
+	'Ą': "A", 'ą': "a", 'Ć': "C", 'ć': "c", 'Č': "C", 'č': "c", 'Ď': "D",
+	'ď': "d", 'Ę': "E", 'ę': "e", 'Ě': "E", 'ě': "e", 'Ł': "L", 'ł': "l",
+	'Ń': "N", 'ń': "n", 'Ň': "N", 'ň': "n", 'Ő': "O", 'ő': "o", 'Œ': "Oe",
+	'œ': "oe", 'Ř': "R", 'ř': "r", 'Ś': "S", 'ś': "s", 'Š': "S", 'š': "s",
+	'Ť': "T", 'ť': "t", 'Ů': "U", 'ů': "u", 'Ű': "U", 'ű': "u", 'Ź': "Z",
+	'ź': "z", 'Ż': "Z", 'ż': "z", 'Ž': "Z", 'ž': "z",
+
+	// Cyrillic.
+	'А': "A", 'Б': "B", 'В': "V", 'Г': "G", 'Д': "D", 'Е': "E", 'Ё': "Yo",
+	'Ж': "Zh", 'З': "Z", 'И': "I", 'Й': "Y", 'К': "K", 'Л': "L", 'М': "M",
+	'Н': "N", 'О': "O", 'П': "P", 'Р': "R", 'С': "S", 'Т': "T", 'У': "U",
+	'Ф': "F", 'Х': "Kh", 'Ц': "Ts", 'Ч': "Ch", 'Ш': "Sh", 'Щ': "Shch",
+	'Ъ': "", 'Ы': "Y", 'Ь': "", 'Э': "E", 'Ю': "Yu", 'Я': "Ya",
+	'Є': "Ye", 'І': "I", 'Ї': "Yi", 'Ґ': "G", 'Ў': "U",
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "yo",
+	'ж': "zh", 'з': "z", 'и': "i", 'й': "y", 'к': "k", 'л': "l", 'м': "m",
+	'н': "n", 'о': "o", 'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u",
+	'ф': "f", 'х': "kh", 'ц': "ts", 'ч': "ch", 'ш': "sh", 'щ': "shch",
+	'ъ': "", 'ы': "y", 'ь': "", 'э': "e", 'ю': "yu", 'я': "ya",
+	'є': "ye", 'і': "i", 'ї': "yi", 'ґ': "g", 'ў': "u",
+}
+
+// nameSanitizer normalizes names to a TerrainBuilder-safe form.
+type nameSanitizer struct {
+	allowed       [128]bool // allowed ASCII characters
+	maxLen        int       // max name length, 0 = unlimited
+	transliterate bool      // transliterate non-ASCII runes
+}
+
+// newNameSanitizer builds a sanitizer from a charset like "A-Za-z0-9_-".
+func newNameSanitizer(charset string, maxLen int, transliterate bool) (*nameSanitizer, error) {
+	if maxLen < 0 {
+		return nil, fmt.Errorf("name max length must be >= 0")
+	}
+
+	s := &nameSanitizer{maxLen: maxLen, transliterate: transliterate}
+	for i := 0; i < len(charset); i++ {
+		c := charset[i]
+		if c >= utf8.RuneSelf {
+			return nil, fmt.Errorf("name charset must be ASCII: %q", charset)
+		}
+
+		// Ranges like "a-z"; a leading or trailing '-' is literal.
+		if i+2 < len(charset) && charset[i+1] == '-' {
+			hi := charset[i+2]
+			if hi >= utf8.RuneSelf || hi < c {
+				return nil, fmt.Errorf("bad name charset range %q", charset[i:i+3])
+			}
+			for r := c; r <= hi; r++ {
+				s.allowed[r] = true
+			}
+			i += 2
+
+			continue
+		}
+
+		s.allowed[c] = true
+	}
+
+	if !s.allowed[nameReplacement] {
+		return nil, fmt.Errorf("name charset must allow %q", nameReplacement)
+	}
+
+	return s, nil
+}
+
+// sanitize replaces disallowed characters and trims the name to maxLen.
+func (s *nameSanitizer) sanitize(name string) string {
+	if s == nil {
+		return name
+	}
+
+	var b strings.Builder
+	b.Grow(len(name))
+	for _, r := range name {
+		if r < utf8.RuneSelf && s.allowed[r] {
+			b.WriteRune(r)
+			continue
+		}
+		if s.transliterate {
+			if t, ok := translit[r]; ok {
+				s.writeASCII(&b, t)
+				continue
+			}
+		}
+		b.WriteRune(nameReplacement)
+	}
+
+	out := collapseReplacement(b.String())
+	if out == "" {
+		out = "unnamed"
+	}
+
+	return s.fit(out, "")
+}
+
+// writeASCII writes a transliterated string, dropping disallowed characters.
+func (s *nameSanitizer) writeASCII(b *strings.Builder, t string) {
+	for i := 0; i < len(t); i++ {
+		if s.allowed[t[i]] {
+			b.WriteByte(t[i])
+		} else {
+			b.WriteRune(nameReplacement)
+		}
+	}
+}
+
+// join joins a sanitized base and suffix with '_' keeping within maxLen.
+func (s *nameSanitizer) join(base string, suffix string) string {
+	if s == nil {
+		return base + "_" + suffix
+	}
+
+	suffix = strings.Trim(s.sanitize(suffix), string(nameReplacement))
+	return s.fit(base, "_"+suffix)
+}
+
+// fit trims base so that base+suffix does not exceed maxLen.
+func (s *nameSanitizer) fit(base string, suffix string) string {
+	if s == nil || s.maxLen == 0 || len(base)+len(suffix) <= s.maxLen {
+		return base + suffix
+	}
+
+	keep := s.maxLen - len(suffix)
+	if keep < 1 {
+		keep = 1
+	}
+	if keep > len(base) {
+		keep = len(base)
+	}
+
+	return strings.TrimRight(base[:keep], string(nameReplacement)) + suffix
+}
+
+// collapseReplacement collapses runs of the replacement character
+// and trims it from both ends.
+func collapseReplacement(s string) string {
+	var b strings.Builder
+	b.Grow(len(s))
+	prev := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == nameReplacement {
+			if prev {
+				continue
+			}
+			prev = true
+		} else {
+			prev = false
+		}
+		b.WriteByte(c)
+	}
+
+	return strings.Trim(b.String(), string(nameReplacement))
+}
+
+// nameChange records a name modified by normalization.
+type nameChange struct {
+	Kind   string // "library" or "template"
+	Source string // group key or model path
+	From   string // original name
+	To     string // emitted name
+}
+
+// nameRegistry hands out sanitized, global-unique library and template names.
+type nameRegistry struct {
+	san       *nameSanitizer
+	templates map[string]struct{} // used template names (lowercase)
+	libraries map[string]struct{} // used library names (lowercase)
+	changes   []nameChange
+}
+
+// newNameRegistry creates an empty registry.
+func newNameRegistry(san *nameSanitizer) *nameRegistry {
+	return &nameRegistry{
+		san:       san,
+		templates: make(map[string]struct{}, 4096),
+		libraries: make(map[string]struct{}, 256),
+	}
+}
+
+// libraryName returns the sanitized lowercase library name for a group key.
+func (r *nameRegistry) libraryName(key string) string {
+	base := r.san.sanitize(strings.ToLower(key))
+	name := base
+	for i := 1; ; i++ {
+		if _, ok := r.libraries[name]; !ok {
+			break
+		}
+		name = r.san.fit(base, fmt.Sprintf("_%d", i))
+	}
+	r.libraries[name] = struct{}{}
+
+	if name != strings.ToLower(key) {
+		r.changes = append(r.changes, nameChange{Kind: "library", Source: key, From: key, To: name})
+	}
+
+	return name
+}
+
+// templateName returns the sanitized global-unique <Name> for a model.
+func (r *nameRegistry) templateName(base string, relPath string) string {
+	clean := r.san.sanitize(base)
+	name := uniqueDisplayName(clean, relPath, r.templates, r.san)
+	if clean != base {
+		r.changes = append(r.changes, nameChange{Kind: "template", Source: relPath, From: base, To: name})
+	}
+
+	return name
+}
+
+// writeReport writes the list of changed names as TSV.
+func (r *nameRegistry) writeReport(w io.Writer) error {
+	if _, err := fmt.Fprintln(w, "kind\tsource\tfrom\tto"); err != nil {
+		return err
+	}
+	for _, c := range r.changes {
+		if _, err := fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", c.Kind, c.Source, c.From, c.To); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
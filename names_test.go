@@ -0,0 +1,72 @@
+package main
+
+import "testing"
+
+func TestNameSanitizerSanitize(t *testing.T) {
+	t.Parallel()
+
+	san, err := newNameSanitizer("A-Za-z0-9_-", 16, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		in   string
+		want string
+	}{
+		{"Land_House", "Land_House"},
+		{"old house.v2", "old_house_v2"},
+		{"Дом_Сарай", "Dom_Saray"},
+		{"Größe", "Groesse"},
+		{"  ..  ", "unnamed"},
+		{"very_long_model_name_here", "very_long_model"},
+	}
+
+	for _, tc := range cases {
+		if got := san.sanitize(tc.in); got != tc.want {
+			t.Fatalf("sanitize(%q)=%q want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestNameSanitizerCharset(t *testing.T) {
+	t.Parallel()
+
+	if _, err := newNameSanitizer("a-z", 0, true); err == nil {
+		t.Fatal("charset without '_' should be rejected")
+	}
+	if _, err := newNameSanitizer("z-a_", 0, true); err == nil {
+		t.Fatal("reversed range should be rejected")
+	}
+}
+
+func TestNameRegistryCollisions(t *testing.T) {
+	t.Parallel()
+
+	san, err := newNameSanitizer("A-Za-z0-9_", 12, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := newNameRegistry(san)
+
+	if got := r.libraryName("dz_a b"); got != "dz_a_b" {
+		t.Fatalf("libraryName got %q want %q", got, "dz_a_b")
+	}
+	if got := r.libraryName("dz_a.b"); got != "dz_a_b_1" {
+		t.Fatalf("libraryName collision got %q want %q", got, "dz_a_b_1")
+	}
+
+	if got := r.templateName("barn shed", "dz/a/barn shed.p3d"); got != "barn_shed" {
+		t.Fatalf("templateName got %q want %q", got, "barn_shed")
+	}
+	if got := r.templateName("barn.shed", "dz/b/barn.shed.p3d"); got != "barn_shed_1" {
+		t.Fatalf("templateName collision got %q want %q", got, "barn_shed_1")
+	}
+	if got := r.templateName("barn_shed_long", "my mod/barn_shed_long.p3d"); got != "barn_shed_lo" {
+		t.Fatalf("templateName trim got %q want %q", got, "barn_shed_lo")
+	}
+
+	if len(r.changes) != 5 {
+		t.Fatalf("changes=%d want 5", len(r.changes))
+	}
+}
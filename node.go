@@ -69,3 +69,16 @@ func nodeKey(n *Node) string {
 
 	return strings.Join(parts, "_")
 }
+
+// nodePath returns the node path relative to the game root, with '/'.
+func nodePath(n *Node) string {
+	var parts []string
+	for cur := n; cur != nil && cur.Parent != nil; cur = cur.Parent {
+		parts = append(parts, cur.Name)
+	}
+	for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+		parts[i], parts[j] = parts[j], parts[i]
+	}
+
+	return strings.Join(parts, "/")
+}
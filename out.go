@@ -1,51 +1,498 @@
 package main
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
 )
 
-// prepareOut prepares the output directory for writing.
-func prepareOut(out string, force bool) {
+// Force modes.
+const (
+	forceNone    = ""         // refuse a non-empty output directory
+	forceAll     = "all"      // replace the whole output directory
+	forceTMLOnly = "tml-only" // replace only .tml files, keep other files
+)
+
+// outputFileMode is the mode of every file written through replaceAtomic.
+const outputFileMode os.FileMode = 0o600
+
+// outputSidecars lists files tml-gen writes next to the libraries.
+var outputSidecars = map[string]struct{}{
+	"legend.html":     {},
+	"legend.svg":      {},
+	"manifest.json":   {},
+	"manifest.csv":    {},
+	"manifest.sqlite": {},
+}
+
+// isOutputFile reports whether a file name in the output dir was written by tml-gen.
+func isOutputFile(name string) bool {
+	lower := strings.ToLower(name)
+	if strings.HasSuffix(lower, ".tml") {
+		return true
+	}
+	if _, ok := outputSidecars[lower]; ok {
+		return true
+	}
+
+	// Leftover temp files of atomic writes.
+	return strings.HasPrefix(name, ".") && strings.HasSuffix(name, ".tmp")
+}
+
+// prepareOut checks that the output directory can be replaced.
+// Nothing is deleted here; the previous output is swapped out only
+// after generation succeeds (see outputStage).
+// In incremental mode a non-empty output directory is updated in place.
+func prepareOut(out string, force string, incremental bool, gameRoot string, scanRoots []string) {
+	if err := checkOutPaths(out, gameRoot, scanRoots); err != nil {
+		fatal(exitUsage, "unsafe out directory", "err", err)
+	}
+
 	st, err := os.Stat(out)
 	if err != nil {
 		if os.IsNotExist(err) {
-			if err := os.MkdirAll(out, 0o750); err != nil {
-				fmt.Fprintln(os.Stderr, "mkdir out error:", err)
-				os.Exit(1)
-			}
 			return
 		}
-		fmt.Fprintln(os.Stderr, "stat out error:", err)
-		os.Exit(1)
+		fatal(exitError, "stat out error", "err", err)
 	}
 
 	if !st.IsDir() {
-		fmt.Fprintln(os.Stderr, "out exists and is not a directory:", out)
-		os.Exit(2)
+		fatal(exitUsage, "out exists and is not a directory", "path", out)
 	}
 
 	ents, err := os.ReadDir(out)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, "readdir out error:", err)
-		os.Exit(1)
+		fatal(exitError, "readdir out error", "err", err)
+	}
+
+	if len(ents) > 0 && force == forceNone && !incremental {
+		fatal(exitUsage, "out directory is not empty (use --force)", "path", out)
+	}
+
+	// Replacing the whole directory is only allowed for our own files.
+	if force == forceAll {
+		if foreign := foreignEntries(ents); len(foreign) > 0 {
+			fatal(exitUsage, "out directory holds files not written by tml-gen (use --force=tml-only)",
+				"path", out, "files", strings.Join(foreign, ", "))
+		}
+	}
+}
+
+// checkOutPaths refuses an output directory that is or contains
+// the game root or a scan root.
+func checkOutPaths(out string, gameRoot string, scanRoots []string) error {
+	if startsWithPathPrefix(gameRoot, out) {
+		return fmt.Errorf("out must not be or contain game-root: %s", out)
 	}
+	for _, root := range scanRoots {
+		if startsWithPathPrefix(root, out) {
+			return fmt.Errorf("out must not be or contain scan path %s: %s", root, out)
+		}
+	}
+
+	return nil
+}
 
-	if len(ents) == 0 {
-		return
+// foreignEntries lists up to 5 directory entries not written by tml-gen.
+func foreignEntries(ents []os.DirEntry) []string {
+	var out []string
+	for _, e := range ents {
+		if e.IsDir() || !isOutputFile(e.Name()) {
+			if len(out) == 5 {
+				out = append(out, "...")
+				break
+			}
+			out = append(out, e.Name())
+		}
 	}
 
-	if !force {
-		fmt.Fprintln(os.Stderr, "out directory is not empty (use --force):", out)
-		os.Exit(2)
+	return out
+}
+
+// outputStage is a staging directory swapped in place of the output
+// directory only when generation succeeds.
+type outputStage struct {
+	out     string // final output directory
+	dir     string // staging directory next to out
+	backups int    // number of rotating out.bak.N backups to keep
+}
+
+// beginOutput creates a fresh staging directory next to out.
+func beginOutput(out string, backups int) (*outputStage, error) {
+	if err := os.MkdirAll(filepath.Dir(out), 0o750); err != nil {
+		return nil, err
 	}
 
-	if err := os.RemoveAll(out); err != nil {
-		fmt.Fprintln(os.Stderr, "failed to remove out:", err)
-		os.Exit(1)
+	s := &outputStage{
+		out:     out,
+		dir:     filepath.Join(filepath.Dir(out), "."+filepath.Base(out)+".staging"),
+		backups: backups,
+	}
+
+	// Leftovers of a crashed run are ours to remove.
+	if err := os.RemoveAll(s.dir); err != nil {
+		return nil, err
+	}
+	if err := os.Mkdir(s.dir, 0o750); err != nil {
+		return nil, err
 	}
-	if err := os.MkdirAll(out, 0o750); err != nil {
-		fmt.Fprintln(os.Stderr, "mkdir out error:", err)
-		os.Exit(1)
+
+	return s, nil
+}
+
+// abort removes the staging directory and keeps the previous output.
+func (s *outputStage) abort() {
+	_ = os.RemoveAll(s.dir)
+}
+
+// commit moves the previous output to a backup and the staging dir into place.
+func (s *outputStage) commit() error {
+	prev := ""
+	st, err := os.Stat(s.out)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return err
+	case !st.IsDir():
+		return fmt.Errorf("out exists and is not a directory: %s", s.out)
+	default:
+		ents, err := os.ReadDir(s.out)
+		if err != nil {
+			return err
+		}
+		if len(ents) == 0 {
+			if err := os.Remove(s.out); err != nil {
+				return err
+			}
+			break
+		}
+
+		if s.backups > 0 {
+			prev, err = rotateBackups(s.out, s.backups)
+		} else {
+			prev = filepath.Join(filepath.Dir(s.out), "."+filepath.Base(s.out)+".old")
+			if err = os.RemoveAll(prev); err == nil {
+				err = os.Rename(s.out, prev)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("move previous output: %w", err)
+		}
+	}
+
+	if err := os.Rename(s.dir, s.out); err != nil {
+		// Put the previous output back.
+		if prev != "" {
+			_ = os.Rename(prev, s.out)
+		}
+		return fmt.Errorf("swap output: %w", err)
+	}
+
+	if prev != "" && s.backups == 0 {
+		return os.RemoveAll(prev)
+	}
+
+	return nil
+}
+
+// commitTMLOnly moves staged files into the output directory one by one
+// and removes stale .tml files; other files in the output are kept.
+// Replaced and stale .tml files are moved to a fresh out.bak.1 when
+// backups are kept.
+func (s *outputStage) commitTMLOnly() error {
+	if err := os.MkdirAll(s.out, 0o750); err != nil {
+		return err
+	}
+
+	bak := ""
+	if s.backups > 0 {
+		var err error
+		if bak, err = shiftBackups(s.out, s.backups); err != nil {
+			return fmt.Errorf("rotate backups: %w", err)
+		}
+	}
+	// retire moves a previous file to the backup or deletes it.
+	retire := func(name string) error {
+		path := filepath.Join(s.out, name)
+		if bak == "" {
+			return os.Remove(path)
+		}
+		if err := os.MkdirAll(bak, 0o750); err != nil {
+			return err
+		}
+		return os.Rename(path, filepath.Join(bak, name))
+	}
+
+	staged, err := os.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	fresh := make(map[string]struct{}, len(staged))
+	for _, e := range staged {
+		if bak != "" {
+			if err := retire(e.Name()); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		if err := os.Rename(filepath.Join(s.dir, e.Name()), filepath.Join(s.out, e.Name())); err != nil {
+			return err
+		}
+		fresh[strings.ToLower(e.Name())] = struct{}{}
+	}
+
+	ents, err := os.ReadDir(s.out)
+	if err != nil {
+		return err
+	}
+	for _, e := range ents {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(strings.ToLower(name), ".tml") {
+			continue
+		}
+		if _, ok := fresh[strings.ToLower(name)]; ok {
+			continue
+		}
+		if err := retire(name); err != nil {
+			return err
+		}
 	}
+
+	return os.Remove(s.dir)
+}
+
+// rotateBackups shifts out.bak.N backups and moves out to out.bak.1.
+func rotateBackups(out string, keep int) (string, error) {
+	bak, err := shiftBackups(out, keep)
+	if err != nil {
+		return "", err
+	}
+	if err := os.Rename(out, bak); err != nil {
+		return "", err
+	}
+
+	return bak, nil
+}
+
+// shiftBackups drops the oldest backup, shifts out.bak.N to out.bak.N+1
+// and returns the now free out.bak.1 path.
+func shiftBackups(out string, keep int) (string, error) {
+	bak := func(i int) string { return fmt.Sprintf("%s.bak.%d", out, i) }
+
+	if err := os.RemoveAll(bak(keep)); err != nil {
+		return "", err
+	}
+	for i := keep - 1; i >= 1; i-- {
+		if err := os.Rename(bak(i), bak(i+1)); err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+	}
+
+	return bak(1), nil
+}
+
+// writeFileAtomic streams content into a temp file next to path
+// and renames it into place, so readers never see a half-written file.
+func writeFileAtomic(path string, write func(w io.Writer) error) error {
+	return replaceAtomic(path, func(tmpPath string) error {
+		f, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_TRUNC, outputFileMode) // #nosec G304 -- temp file created by us
+		if err != nil {
+			return err
+		}
+
+		err = write(f)
+		if err == nil {
+			err = f.Sync()
+		}
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		return err
+	})
+}
+
+// replaceAtomic creates an empty temp file next to path, lets fill write it
+// by name and renames it into place; the temp file is removed on any error.
+func replaceAtomic(path string, fill func(tmpPath string) error) (err error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	if err = tmp.Close(); err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = os.Remove(tmpPath)
+		}
+	}()
+
+	if err = fill(tmpPath); err != nil {
+		return err
+	}
+	if err = os.Chmod(tmpPath, outputFileMode); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
+
+// reTMLDate matches template dates that change on every run.
+var reTMLDate = regexp.MustCompile(`<Date>[^<]*</Date>`)
+
+// sameIgnoringDates compares rendered files ignoring template <Date> values.
+func sameIgnoringDates(a, b []byte) bool {
+	return bytes.Equal(reTMLDate.ReplaceAll(a, nil), reTMLDate.ReplaceAll(b, nil))
+}
+
+// outputSummary counts files touched by an incremental run.
+type outputSummary struct {
+	Stale     []string // stale .tml files kept (without --prune)
+	Created   int
+	Updated   int
+	Unchanged int
+	Removed   int
+}
+
+// outputSink writes generated files into a directory. In incremental mode
+// files are rendered in memory first and rewritten only when changed.
+type outputSink struct {
+	written     map[string]struct{} // lowercase file names produced in this run
+	dir         string
+	summary     outputSummary
+	incremental bool
+}
+
+// newOutputSink creates a sink writing into dir.
+func newOutputSink(dir string, incremental bool) *outputSink {
+	return &outputSink{dir: dir, incremental: incremental, written: make(map[string]struct{})}
+}
+
+// writeFile writes a file produced by render; same decides whether
+// the existing content is equivalent and can be kept.
+func (o *outputSink) writeFile(name string, render func(w io.Writer) error, same func(a, b []byte) bool) error {
+	o.written[strings.ToLower(name)] = struct{}{}
+	path := filepath.Join(o.dir, name)
+	if !o.incremental {
+		return writeFileAtomic(path, render)
+	}
+
+	var buf bytes.Buffer
+	if err := render(&buf); err != nil {
+		return err
+	}
+
+	old, err := os.ReadFile(path) // #nosec G304 -- path is inside the output dir
+	switch {
+	case os.IsNotExist(err):
+		o.summary.Created++
+	case err != nil:
+		return err
+	case same(old, buf.Bytes()):
+		o.summary.Unchanged++
+		return nil
+	default:
+		o.summary.Updated++
+	}
+
+	return writeFileAtomic(path, func(w io.Writer) error {
+		_, err := w.Write(buf.Bytes())
+		return err
+	})
+}
+
+// keep records an existing file as produced without rewriting it.
+// It reports false when the file is missing and has to be written.
+func (o *outputSink) keep(name string) bool {
+	if _, err := os.Stat(filepath.Join(o.dir, name)); err != nil {
+		return false
+	}
+	o.written[strings.ToLower(name)] = struct{}{}
+	o.summary.Unchanged++
+	return true
+}
+
+// errUnchanged aborts replaceAtomic when the new file equals the old one.
+var errUnchanged = errors.New("file unchanged")
+
+// replaceFile atomically replaces a file written by name (e.g. a database).
+// In incremental mode the new file is compared with the existing one
+// byte for byte and the existing file is kept when they are equal.
+func (o *outputSink) replaceFile(name string, fill func(tmpPath string) error) error {
+	o.written[strings.ToLower(name)] = struct{}{}
+	path := filepath.Join(o.dir, name)
+	if !o.incremental {
+		return replaceAtomic(path, fill)
+	}
+
+	old, err := os.ReadFile(path) // #nosec G304 -- path is inside the output dir
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	exists := err == nil
+
+	err = replaceAtomic(path, func(tmpPath string) error {
+		if err := fill(tmpPath); err != nil {
+			return err
+		}
+		if !exists {
+			return nil
+		}
+		cur, err := os.ReadFile(tmpPath) // #nosec G304 -- temp file created above
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(old, cur) {
+			return errUnchanged
+		}
+		return nil
+	})
+	switch {
+	case errors.Is(err, errUnchanged):
+		o.summary.Unchanged++
+		return nil
+	case err != nil:
+		return err
+	case exists:
+		o.summary.Updated++
+	default:
+		o.summary.Created++
+	}
+
+	return nil
+}
+
+// pruneStale removes (or with prune=false only lists) .tml files
+// that were not produced in this run.
+func (o *outputSink) pruneStale(prune bool) error {
+	ents, err := os.ReadDir(o.dir)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range ents {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(strings.ToLower(name), ".tml") {
+			continue
+		}
+		if _, ok := o.written[strings.ToLower(name)]; ok {
+			continue
+		}
+		if !prune {
+			o.summary.Stale = append(o.summary.Stale, name)
+			continue
+		}
+		if err := os.Remove(filepath.Join(o.dir, name)); err != nil {
+			return err
+		}
+		o.summary.Removed++
+	}
+
+	return nil
 }
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "lib.tml")
+	if err := os.WriteFile(path, []byte("old"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	failed := errors.New("boom")
+	err := writeFileAtomic(path, func(w io.Writer) error {
+		_, _ = io.WriteString(w, "partial")
+		return failed
+	})
+	if !errors.Is(err, failed) {
+		t.Fatalf("err=%v want %v", err, failed)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "old" {
+		t.Fatalf("failed write replaced the file: %q", data)
+	}
+
+	if err := writeFileAtomic(path, func(w io.Writer) error {
+		_, err := io.WriteString(w, "new")
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "new" {
+		t.Fatalf("content=%q want %q", data, "new")
+	}
+
+	ents, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ents) != 1 {
+		t.Fatalf("temp files left behind: %d entries", len(ents))
+	}
+}
+
+func TestOutputStageRotatesBackups(t *testing.T) {
+	t.Parallel()
+
+	out := filepath.Join(t.TempDir(), "out")
+	for i, content := range []string{"one", "two", "three"} {
+		s, err := beginOutput(out, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(s.dir, "lib.tml"), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.commit(); err != nil {
+			t.Fatalf("commit #%d: %v", i+1, err)
+		}
+	}
+
+	for path, want := range map[string]string{
+		out:            "three",
+		out + ".bak.1": "two",
+		out + ".bak.2": "one",
+	} {
+		data, err := os.ReadFile(filepath.Join(path, "lib.tml"))
+		if err != nil || string(data) != want {
+			t.Fatalf("%s: %q, %v want %q", path, data, err, want)
+		}
+	}
+	if _, err := os.Stat(out + ".bak.3"); !os.IsNotExist(err) {
+		t.Fatal("backup beyond --backups should not exist")
+	}
+}
+
+func TestOutputStageAbortKeepsOutput(t *testing.T) {
+	t.Parallel()
+
+	out := filepath.Join(t.TempDir(), "out")
+	if err := os.MkdirAll(out, 0o750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(out, "lib.tml"), []byte("old"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := beginOutput(out, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.abort()
+
+	if _, err := os.Stat(s.dir); !os.IsNotExist(err) {
+		t.Fatal("staging dir left behind")
+	}
+	if data, _ := os.ReadFile(filepath.Join(out, "lib.tml")); string(data) != "old" {
+		t.Fatalf("output changed: %q", data)
+	}
+}
+
+func TestCheckOutPaths(t *testing.T) {
+	t.Parallel()
+
+	root := filepath.Join(t.TempDir(), "p")
+	scan := filepath.Join(root, "dz")
+
+	cases := []struct {
+		out     string
+		wantErr bool
+	}{
+		{root, true},
+		{filepath.Dir(root), true},
+		{scan, true},
+		{filepath.Join(root, "out"), false},
+		{filepath.Join(scan, "structures"), false},
+	}
+	for _, tc := range cases {
+		err := checkOutPaths(tc.out, root, []string{scan})
+		if (err != nil) != tc.wantErr {
+			t.Fatalf("checkOutPaths(%q) err=%v wantErr=%v", tc.out, err, tc.wantErr)
+		}
+	}
+}
+
+func TestCheckOutPathsRelative(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "p")
+	scan := filepath.Join(root, "dz")
+	if err := os.MkdirAll(scan, 0o750); err != nil {
+		t.Fatal(err)
+	}
+	t.Chdir(scan)
+
+	// A relative out is resolved before it is compared with absolute roots.
+	cases := []struct {
+		out     string
+		wantErr bool
+	}{
+		{"..", true},
+		{".", true},
+		{"../..", true},
+		{"../out", false},
+		{"structures", false},
+	}
+	for _, tc := range cases {
+		err := checkOutPaths(cleanAbs(tc.out), root, []string{scan})
+		if (err != nil) != tc.wantErr {
+			t.Fatalf("checkOutPaths(%q) err=%v wantErr=%v", tc.out, err, tc.wantErr)
+		}
+	}
+}
+
+func TestCommitTMLOnlyKeepsForeignFiles(t *testing.T) {
+	t.Parallel()
+
+	out := filepath.Join(t.TempDir(), "out")
+	if err := os.MkdirAll(out, 0o750); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"notes.txt", "stale.tml", "keep.tml"} {
+		if err := os.WriteFile(filepath.Join(out, name), []byte("old"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s, err := beginOutput(out, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(s.dir, "keep.tml"), []byte("new"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.commitTMLOnly(); err != nil {
+		t.Fatal(err)
+	}
+
+	if data, _ := os.ReadFile(filepath.Join(out, "keep.tml")); string(data) != "new" {
+		t.Fatalf("keep.tml=%q want new", data)
+	}
+	if _, err := os.Stat(filepath.Join(out, "stale.tml")); !os.IsNotExist(err) {
+		t.Fatal("stale.tml should be removed")
+	}
+	if _, err := os.Stat(filepath.Join(out, "notes.txt")); err != nil {
+		t.Fatal("notes.txt should be kept")
+	}
+
+	// Replaced and stale libraries are backed up, foreign files are not.
+	for name, want := range map[string]string{"keep.tml": "old", "stale.tml": "old"} {
+		if data, _ := os.ReadFile(filepath.Join(out+".bak.1", name)); string(data) != want {
+			t.Fatalf("backup %s=%q want %q", name, data, want)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(out+".bak.1", "notes.txt")); !os.IsNotExist(err) {
+		t.Fatal("notes.txt should not be backed up")
+	}
+}
+
+func TestOutputSinkIncremental(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	render := func(date string) func(w io.Writer) error {
+		return func(w io.Writer) error {
+			_, err := io.WriteString(w, "<Library>\n\t<Date>"+date+"</Date>\n</Library>\n")
+			return err
+		}
+	}
+
+	sink := newOutputSink(dir, true)
+	if err := sink.writeFile("a.tml", render("01/01/25 10:00:00"), sameIgnoringDates); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "gone.tml"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	sink = newOutputSink(dir, true)
+	if err := sink.writeFile("a.tml", render("02/02/25 11:00:00"), sameIgnoringDates); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.pruneStale(true); err != nil {
+		t.Fatal(err)
+	}
+
+	if sink.summary.Unchanged != 1 || sink.summary.Removed != 1 || sink.summary.Created != 0 {
+		t.Fatalf("summary=%+v", sink.summary)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "a.tml")); !bytes.Contains(data, []byte("01/01/25")) {
+		t.Fatalf("unchanged file was rewritten: %q", data)
+	}
+}
+
+func TestOutputSinkReplaceFile(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	fill := func(data string) func(tmpPath string) error {
+		return func(tmpPath string) error {
+			return os.WriteFile(tmpPath, []byte(data), 0o600)
+		}
+	}
+
+	for i, tc := range []struct {
+		data string
+		want outputSummary
+	}{
+		{"db1", outputSummary{Created: 1}},
+		{"db1", outputSummary{Unchanged: 1}},
+		{"db2", outputSummary{Updated: 1}},
+	} {
+		sink := newOutputSink(dir, true)
+		if err := sink.replaceFile("manifest.sqlite", fill(tc.data)); err != nil {
+			t.Fatal(err)
+		}
+		if sink.summary.Created != tc.want.Created || sink.summary.Updated != tc.want.Updated || sink.summary.Unchanged != tc.want.Unchanged {
+			t.Fatalf("run %d: summary=%+v want %+v", i, sink.summary, tc.want)
+		}
+		if data, _ := os.ReadFile(filepath.Join(dir, "manifest.sqlite")); string(data) != tc.data {
+			t.Fatalf("run %d: file=%q want %q", i, data, tc.data)
+		}
+	}
+
+	if ents, _ := os.ReadDir(dir); len(ents) != 1 {
+		t.Fatalf("temp files left: %v", ents)
+	}
+}
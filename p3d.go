@@ -0,0 +1,414 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+)
+
+// P3D limits guarding against corrupt files.
+const (
+	p3dMaxLODs   = 1024
+	p3dMaxCount  = 1 << 24
+	p3dMaxString = 4096
+)
+
+// Length of the name and value of a named property tag.
+const p3dPropertyLen = 64
+
+// Resolutions at and above this value are special (non-visual) LODs.
+const p3dSpecialLOD = 1e3
+
+// P3DInfo holds metadata read from a p3d model.
+type P3DInfo struct {
+	Format    string     // "ODOL" (binarized) or "MLOD" (editable)
+	LODs      []float32  // LOD resolutions in file order
+	BBoxMin   [3]float32 // bounding box min (X, Y, Z), Y is up
+	BBoxMax   [3]float32 // bounding box max (X, Y, Z), Y is up
+	Roundness float32    // share of footprint points inside the inscribed ellipse, -1 if unknown
+	Version   uint32     // format version
+	HasBBox   bool       // bounding box is known
+
+	// Named properties with lowercase names. ODOL keeps only
+	// "class" and "damage", MLOD keeps all of them.
+	Properties map[string]string
+}
+
+// readP3DInfo reads model metadata from a p3d file.
+func readP3DInfo(path string) (*P3DInfo, error) {
+	f, err := os.Open(path) // #nosec G304 -- model path found by scanning
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	// Callers record errors by path, so the path is not repeated here.
+	return parseP3D(bufio.NewReaderSize(f, 64<<10))
+}
+
+// parseP3D parses ODOL or MLOD model metadata.
+func parseP3D(r io.Reader) (*P3DInfo, error) {
+	br := &p3dReader{r: r}
+	sig := br.sig()
+	version := br.u32()
+	if br.err != nil {
+		return nil, br.err
+	}
+
+	var info *P3DInfo
+	var err error
+	switch sig {
+	case "ODOL":
+		info, err = parseODOL(br, version)
+	case "MLOD":
+		info, err = parseMLOD(br)
+	default:
+		return nil, fmt.Errorf("unsupported p3d signature %q", sig)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	info.Format = sig
+	info.Version = version
+	return info, nil
+}
+
+// parseODOL reads the LOD list, the bounding box and the named properties
+// from the ODOL model info.
+// LOD geometry is not decoded, so Roundness stays unknown (-1).
+func parseODOL(br *p3dReader, version uint32) (*P3DInfo, error) {
+	if version >= 59 {
+		br.u32() // app id
+	}
+	if version >= 58 {
+		br.asciiz() // model prefix
+	}
+
+	n := br.count(p3dMaxLODs)
+	info := &P3DInfo{LODs: make([]float32, 0, n), Roundness: -1}
+	for i := 0; i < n && br.err == nil; i++ {
+		info.LODs = append(info.LODs, br.f32())
+	}
+
+	// Model info: special, bounding sphere, geometry sphere, remarks,
+	// and/or hints, aiming center, map colors and view density.
+	br.skip(4*6 + 12 + 4*3)
+	info.BBoxMin = br.vec3()
+	info.BBoxMax = br.vec3()
+	if br.err != nil {
+		return nil, br.err
+	}
+
+	info.HasBBox = validBBox(info.BBoxMin, info.BBoxMax)
+
+	// The bounding box is enough to place the model, so a model info
+	// that can't be followed further only leaves the properties unknown.
+	info.Properties = readODOLProperties(br, version)
+	return info, nil
+}
+
+// readODOLProperties reads the class and damage named properties stored
+// at the end of the ODOL model info, right after the bounding box.
+// It returns nil when the layout can't be followed, e.g. when the mass
+// array is compressed.
+func readODOLProperties(br *p3dReader, version uint32) map[string]string {
+	at := func(v uint32, n int64) int64 {
+		if version >= v {
+			return n
+		}
+		return 0
+	}
+
+	// LOD density and draw importance, visual bounding box, bounding,
+	// geometry and mass centers, inverse inertia, center and occlusion
+	// flags, AI covers, armor and damage factors, alpha and shadow options.
+	br.skip(at(70, 4) + at(71, 4) + at(52, 24) + 12*6 + 4 + at(73, 1) +
+		at(42, 16) + at(43, 8) + at(33, 1) + at(37, 5) + at(48, 4))
+
+	br.skip(1) // animated
+	if skeleton := br.asciiz(); skeleton != "" {
+		br.skip(at(23, 1)) // discrete
+		for n := br.count(p3dMaxCount); n > 0 && br.err == nil; n-- {
+			br.asciiz() // bone
+			br.asciiz() // parent
+		}
+		if version > 40 {
+			br.asciiz() // obsolete pivots name
+		}
+	}
+	br.skip(1) // map type
+
+	// Mass array, stored plain unless compressed.
+	if n := br.count(p3dMaxCount); n > 0 {
+		compressed := n*4 >= 1024
+		if version >= 64 {
+			compressed = br.byte() != 0
+		}
+		if compressed {
+			return nil
+		}
+		br.skip(int64(n) * 4)
+	}
+
+	// Mass, armor and their inverses, explosion shielding, geometry
+	// LOD indices, minimal shadow and blending.
+	br.skip(16 + at(72, 4) + at(53, 1) + at(54, 1) + 12 + 4 + at(38, 1))
+	class := br.asciiz()
+	damage := br.asciiz()
+	if br.err != nil {
+		return nil
+	}
+
+	props := make(map[string]string, 2)
+	if class != "" {
+		props["class"] = class
+	}
+	if damage != "" {
+		props["damage"] = damage
+	}
+	if len(props) == 0 {
+		return nil
+	}
+	return props
+}
+
+// parseMLOD reads all LODs and computes the bounding box from the first visual LOD.
+func parseMLOD(br *p3dReader) (*P3DInfo, error) {
+	n := br.count(p3dMaxLODs)
+	info := &P3DInfo{LODs: make([]float32, 0, n), Roundness: -1}
+
+	for i := 0; i < n && br.err == nil; i++ {
+		points, err := readMLODLod(br, info)
+		if err != nil {
+			return nil, err
+		}
+		res := br.f32()
+		info.LODs = append(info.LODs, res)
+
+		if !info.HasBBox && res < p3dSpecialLOD && len(points) > 0 {
+			info.BBoxMin, info.BBoxMax = pointsBBox(points)
+			info.HasBBox = validBBox(info.BBoxMin, info.BBoxMax)
+			info.Roundness = footprintRoundness(points, info.BBoxMin, info.BBoxMax)
+		}
+	}
+	if br.err != nil {
+		return nil, br.err
+	}
+
+	return info, nil
+}
+
+// readMLODLod reads a single P3DM LOD and returns its points.
+// Named properties are collected into info.
+func readMLODLod(br *p3dReader, info *P3DInfo) ([][3]float32, error) {
+	if sig := br.sig(); br.err == nil && sig != "P3DM" {
+		return nil, fmt.Errorf("unsupported LOD signature %q", sig)
+	}
+	br.skip(8) // major and minor version
+	nPoints := br.count(p3dMaxCount)
+	nNormals := br.count(p3dMaxCount)
+	nFaces := br.count(p3dMaxCount)
+	br.skip(4) // flags
+
+	points := make([][3]float32, 0, min(nPoints, 1<<16))
+	for i := 0; i < nPoints && br.err == nil; i++ {
+		points = append(points, br.vec3())
+		br.skip(4) // point flags
+	}
+	br.skip(int64(nNormals) * 12)
+
+	// Face: vertex count, 4 vertices (point, normal, u, v), flags, texture, material.
+	for i := 0; i < nFaces && br.err == nil; i++ {
+		br.skip(4 + 4*16 + 4)
+		br.asciiz()
+		br.asciiz()
+	}
+
+	if sig := br.sig(); br.err == nil && sig != "TAGG" {
+		return nil, fmt.Errorf("unexpected %q instead of TAGG", sig)
+	}
+	for br.err == nil {
+		br.skip(1) // active flag
+		name := br.asciiz()
+		size := br.u32()
+		switch {
+		case name == "#EndOfFile#":
+			return points, br.err
+		case name == "#Property#" && size == 2*p3dPropertyLen:
+			key, value := br.fixedString(p3dPropertyLen), br.fixedString(p3dPropertyLen)
+			if br.err == nil && key != "" {
+				if info.Properties == nil {
+					info.Properties = make(map[string]string)
+				}
+				info.Properties[strings.ToLower(key)] = value
+			}
+		default:
+			br.skip(int64(size))
+		}
+	}
+
+	return points, br.err
+}
+
+// pointsBBox returns the bounding box of points.
+func pointsBBox(points [][3]float32) ([3]float32, [3]float32) {
+	lo, hi := points[0], points[0]
+	for _, p := range points[1:] {
+		for k := 0; k < 3; k++ {
+			lo[k] = min(lo[k], p[k])
+			hi[k] = max(hi[k], p[k])
+		}
+	}
+
+	return lo, hi
+}
+
+// footprintRoundness returns the share of points whose X/Z footprint lies
+// inside the ellipse inscribed into the bounding box.
+func footprintRoundness(points [][3]float32, lo, hi [3]float32) float32 {
+	cx, cz := (lo[0]+hi[0])/2, (lo[2]+hi[2])/2
+	rx, rz := (hi[0]-lo[0])/2, (hi[2]-lo[2])/2
+	if rx <= 0 || rz <= 0 {
+		return -1
+	}
+
+	inside := 0
+	for _, p := range points {
+		u, v := (p[0]-cx)/rx, (p[2]-cz)/rz
+		if u*u+v*v <= 1.05 {
+			inside++
+		}
+	}
+
+	return float32(inside) / float32(len(points))
+}
+
+// validBBox reports whether a bounding box is finite and not inverted.
+func validBBox(lo, hi [3]float32) bool {
+	for k := 0; k < 3; k++ {
+		if math.IsNaN(float64(lo[k])) || math.IsNaN(float64(hi[k])) ||
+			math.IsInf(float64(lo[k]), 0) || math.IsInf(float64(hi[k]), 0) || lo[k] > hi[k] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// p3dReader is a little-endian reader that keeps the first error.
+type p3dReader struct {
+	r   io.Reader
+	err error
+	buf [4]byte
+}
+
+// read fills p unless an error already happened.
+func (br *p3dReader) read(p []byte) {
+	if br.err != nil {
+		return
+	}
+	if _, err := io.ReadFull(br.r, p); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		br.err = err
+	}
+}
+
+// sig reads a 4 byte signature.
+func (br *p3dReader) sig() string {
+	br.read(br.buf[:4])
+	return string(br.buf[:4])
+}
+
+// u32 reads a uint32.
+func (br *p3dReader) u32() uint32 {
+	br.read(br.buf[:4])
+	if br.err != nil {
+		return 0
+	}
+
+	return binary.LittleEndian.Uint32(br.buf[:4])
+}
+
+// byte reads a single byte.
+func (br *p3dReader) byte() byte {
+	br.read(br.buf[:1])
+	if br.err != nil {
+		return 0
+	}
+
+	return br.buf[0]
+}
+
+// f32 reads a float32.
+func (br *p3dReader) f32() float32 {
+	return math.Float32frombits(br.u32())
+}
+
+// vec3 reads three float32 values.
+func (br *p3dReader) vec3() [3]float32 {
+	return [3]float32{br.f32(), br.f32(), br.f32()}
+}
+
+// count reads a uint32 count and checks it against a limit.
+func (br *p3dReader) count(limit int) int {
+	n := br.u32()
+	if br.err == nil && n > uint32(limit) { // #nosec G115 -- limits are small constants
+		br.err = fmt.Errorf("implausible count %d", n)
+	}
+	if br.err != nil {
+		return 0
+	}
+
+	return int(n)
+}
+
+// asciiz reads a zero-terminated string.
+func (br *p3dReader) asciiz() string {
+	var out []byte
+	for br.err == nil {
+		br.read(br.buf[:1])
+		if br.err != nil || br.buf[0] == 0 {
+			break
+		}
+		if len(out) >= p3dMaxString {
+			br.err = errors.New("string too long")
+			break
+		}
+		out = append(out, br.buf[0])
+	}
+
+	return string(out)
+}
+
+// fixedString reads a zero-padded string of n bytes.
+func (br *p3dReader) fixedString(n int) string {
+	buf := make([]byte, n)
+	br.read(buf)
+	if i := bytes.IndexByte(buf, 0); i >= 0 {
+		buf = buf[:i]
+	}
+
+	return string(buf)
+}
+
+// skip discards n bytes.
+func (br *p3dReader) skip(n int64) {
+	if br.err != nil || n <= 0 {
+		return
+	}
+	if _, err := io.CopyN(io.Discard, br.r, n); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		br.err = err
+	}
+}
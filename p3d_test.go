@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+// p3dBuilder writes little-endian p3d test data.
+type p3dBuilder struct{ bytes.Buffer }
+
+func (b *p3dBuilder) u32(v uint32) { _ = binary.Write(&b.Buffer, binary.LittleEndian, v) }
+func (b *p3dBuilder) f32(v float32) {
+	b.u32(math.Float32bits(v))
+}
+func (b *p3dBuilder) str(s string) { b.WriteString(s); b.WriteByte(0) }
+
+// mlodLod writes a P3DM LOD with points, named properties and no faces.
+func (b *p3dBuilder) mlodLod(points [][3]float32, res float32, props ...[2]string) {
+	b.WriteString("P3DM")
+	b.u32(0x1C)
+	b.u32(0x100)
+	b.u32(uint32(len(points)))
+	b.u32(0)
+	b.u32(0)
+	b.u32(0)
+	for _, p := range points {
+		b.f32(p[0])
+		b.f32(p[1])
+		b.f32(p[2])
+		b.u32(0)
+	}
+	b.WriteString("TAGG")
+	b.WriteByte(1)
+	b.str("#Mass#")
+	b.u32(4)
+	b.f32(100)
+	for _, p := range props {
+		b.WriteByte(1)
+		b.str("#Property#")
+		b.u32(128)
+		for _, v := range p {
+			field := make([]byte, 64)
+			copy(field, v)
+			b.Write(field)
+		}
+	}
+	b.WriteByte(1)
+	b.str("#EndOfFile#")
+	b.u32(0)
+	b.f32(res)
+}
+
+func circlePoints(n int, rx, rz float32) [][3]float32 {
+	pts := make([][3]float32, 0, n)
+	for i := 0; i < n; i++ {
+		a := 2 * math.Pi * float64(i) / float64(n)
+		pts = append(pts, [3]float32{rx * float32(math.Cos(a)), 1, rz * float32(math.Sin(a))})
+	}
+	return pts
+}
+
+func TestParseMLODRound(t *testing.T) {
+	t.Parallel()
+
+	var b p3dBuilder
+	b.WriteString("MLOD")
+	b.u32(0x101)
+	b.u32(2)
+	b.mlodLod(circlePoints(32, 3, 3), 1)
+	b.mlodLod([][3]float32{{0, 0, 0}}, 1e13)
+
+	info, err := parseP3D(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Format != "MLOD" || len(info.LODs) != 2 || !info.HasBBox {
+		t.Fatalf("unexpected info: %+v", info)
+	}
+	if got := footprintShape(info); got != "ellipse" {
+		t.Fatalf("footprintShape=%q want ellipse (roundness %.2f)", got, info.Roundness)
+	}
+}
+
+func TestParseMLODBox(t *testing.T) {
+	t.Parallel()
+
+	var b p3dBuilder
+	b.WriteString("MLOD")
+	b.u32(0x101)
+	b.u32(1)
+	b.mlodLod([][3]float32{{-2, 0, -2}, {2, 0, -2}, {2, 0, 2}, {-2, 0, 2}, {-2, 3, -2}, {2, 3, 2}}, 1)
+
+	info, err := parseP3D(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := footprintShape(info); got != "rectangle" {
+		t.Fatalf("footprintShape=%q want rectangle (roundness %.2f)", got, info.Roundness)
+	}
+}
+
+func TestParseODOLBBox(t *testing.T) {
+	t.Parallel()
+
+	var b p3dBuilder
+	b.WriteString("ODOL")
+	b.u32(73)
+	b.u32(0)    // app id
+	b.str("dz") // prefix
+	b.u32(2)    // lods
+	b.f32(1)    // resolution
+	b.f32(1e13) // geometry
+	for i := 0; i < 6+3+3; i++ {
+		b.u32(0)
+	}
+	for _, v := range []float32{-10, 0, -1, 10, 2, 1} {
+		b.f32(v)
+	}
+
+	info, err := parseP3D(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.HasBBox || info.BBoxMax[0] != 10 || info.Roundness >= 0 {
+		t.Fatalf("unexpected info: %+v", info)
+	}
+	if got := footprintShape(info); got != "rectangle" {
+		t.Fatalf("footprintShape=%q want rectangle for long footprint", got)
+	}
+
+	// Without points a compact ODOL footprint keeps the library shape.
+	info.BBoxMin, info.BBoxMax = [3]float32{-3, 0, -3}, [3]float32{3, 8, 3}
+	if got := footprintShape(info); got != "" {
+		t.Fatalf("footprintShape=%q want library shape for ODOL", got)
+	}
+}
+
+// odolModel writes a version 73 ODOL header through the named properties,
+// with a one bone skeleton and a two element mass array.
+func odolModel(class string, compressedMass bool) *p3dBuilder {
+	var b p3dBuilder
+	b.WriteString("ODOL")
+	b.u32(73)
+	b.u32(0)    // app id
+	b.str("dz") // prefix
+	b.u32(2)    // lods
+	b.f32(1)    // resolution
+	b.f32(1e13) // geometry
+	for i := 0; i < 6+3+3; i++ {
+		b.u32(0)
+	}
+	for _, v := range []float32{-1, 0, -1, 1, 2, 1} {
+		b.f32(v)
+	}
+
+	// Density, importance, visual bbox, centers, inertia, flags and factors.
+	b.Write(make([]byte, 4+4+24+12*6+4+1+16+8+1+5+4))
+	b.WriteByte(0)    // animated
+	b.str("skeleton") // skeleton name
+	b.WriteByte(0)    // discrete
+	b.u32(1)          // bones
+	b.str("bone")     // bone
+	b.str("")         // parent
+	b.str("")         // pivots
+	b.WriteByte(0)    // map type
+	b.u32(2)          // mass array
+	if compressedMass {
+		b.WriteByte(1)
+		return &b
+	}
+	b.WriteByte(0)
+	b.f32(1)
+	b.f32(2)
+	b.Write(make([]byte, 16+4+1+1+12+4+1))
+	b.str(class)
+	b.str("tree")
+	b.WriteByte(0) // frequent
+	return &b
+}
+
+func TestParseODOLProperties(t *testing.T) {
+	t.Parallel()
+
+	info, err := parseP3D(odolModel("Proxy", false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Properties["class"] != "Proxy" || info.Properties["damage"] != "tree" {
+		t.Fatalf("properties=%v", info.Properties)
+	}
+	if got := unplaceableReason(info); got != reasonClass+" proxy" {
+		t.Fatalf("unplaceableReason=%q", got)
+	}
+
+	// A compressed mass array hides the properties but keeps the model.
+	info, err = parseP3D(odolModel("proxy", true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Properties != nil || !info.HasBBox {
+		t.Fatalf("unexpected info: %+v", info)
+	}
+	if got := unplaceableReason(info); got != "" {
+		t.Fatalf("unplaceableReason=%q", got)
+	}
+}
+
+func TestParseP3DTruncated(t *testing.T) {
+	t.Parallel()
+
+	if _, err := parseP3D(bytes.NewReader([]byte("MLOD\x01\x01"))); err == nil {
+		t.Fatal("truncated file should fail")
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/woozymasta/tml-gen/tml"
+)
+
+// Fallback color modes for libraries not matched by the theme.
+const (
+	paletteOKLCH  = "oklch"   // hashed hue in OKLCH with collision avoidance
+	paletteCBSafe = "cb-safe" // Okabe-Ito color-blind-safe palette
+	paletteLegacy = "legacy"  // hashColor RGB cube
+)
+
+// goldenAngle spreads successive hue candidates around the color wheel.
+const goldenAngle = 137.50776405003785
+
+// fallbackLightness lists mid-tone OKLCH lightness levels for fallback fills.
+var fallbackLightness = []float64{0.52, 0.62, 0.72}
+
+// fallbackChroma is the OKLCH chroma for fallback fills.
+const fallbackChroma = 0.11
+
+// shadeSteps lists OKLCH lightness offsets for per-template shades.
+var shadeSteps = []float64{-0.12, -0.06, 0.06, 0.12}
+
+// okabeIto is the Okabe-Ito color-blind-safe palette (without black).
+var okabeIto = []int{
+	rgb(230, 159, 0),
+	rgb(86, 180, 233),
+	rgb(0, 158, 115),
+	rgb(240, 228, 66),
+	rgb(0, 114, 178),
+	rgb(213, 94, 0),
+	rgb(204, 121, 167),
+	rgb(153, 153, 153),
+}
+
+// oklab is a color in the OKLab space.
+type oklab struct{ L, A, B float64 }
+
+// colorAllocator assigns fallback colors that keep a minimum distance
+// to every color already used in the run.
+type colorAllocator struct {
+	mode     string
+	used     []oklab
+	minDelta float64 // minimum ΔE in OKLab units x100
+}
+
+// newColorAllocator creates an allocator for a fallback mode.
+func newColorAllocator(mode string, minDelta float64) (*colorAllocator, error) {
+	switch mode {
+	case paletteOKLCH, paletteCBSafe, paletteLegacy:
+	default:
+		return nil, fmt.Errorf("unknown fallback colors %q (want %s, %s or %s)", mode, paletteOKLCH, paletteCBSafe, paletteLegacy)
+	}
+	if minDelta < 0 {
+		return nil, fmt.Errorf("min delta E must be >= 0")
+	}
+
+	return &colorAllocator{mode: mode, minDelta: minDelta}, nil
+}
+
+// reserve marks a color as used so fallback colors keep away from it.
+func (a *colorAllocator) reserve(c int) {
+	a.used = append(a.used, argbToOklab(c))
+}
+
+// fallback returns a deterministic color for a library name.
+func (a *colorAllocator) fallback(name string) int {
+	h := uint32(tml.Hash(strings.ToLower(name))) // #nosec G115 -- hash bit pattern
+
+	var candidates []int
+	switch a.mode {
+	case paletteLegacy:
+		c := hashColor(name)
+		a.reserve(c)
+		return c
+
+	case paletteCBSafe:
+		// Palette colors first, then lighter and darker variants of them.
+		n := uint32(len(okabeIto)) // #nosec G115 -- small constant
+		for _, dl := range []float64{0, -0.12, 0.12, -0.22} {
+			for k := uint32(0); k < n; k++ {
+				c := okabeIto[(h+k)%n]
+				if dl != 0 {
+					lch := oklabToLCH(argbToOklab(c))
+					lch[0] = clamp01(lch[0] + dl)
+					c = lchToARGB(lch[0], lch[1], lch[2])
+				}
+				candidates = append(candidates, c)
+			}
+		}
+
+	default:
+		hue := float64(h%3600) / 10
+		level := int(h>>12) % len(fallbackLightness)
+		for k := 0; k < 36; k++ {
+			l := fallbackLightness[(level+k/12)%len(fallbackLightness)]
+			candidates = append(candidates, lchToARGB(l, fallbackChroma, math.Mod(hue+float64(k)*goldenAngle, 360)))
+		}
+	}
+
+	// Take the first candidate far enough from used colors,
+	// otherwise the one with the largest distance.
+	best, bestDist := candidates[0], -1.0
+	for _, c := range candidates {
+		d := a.nearest(argbToOklab(c))
+		if d >= a.minDelta {
+			best = c
+			break
+		}
+		if d > bestDist {
+			best, bestDist = c, d
+		}
+	}
+
+	a.reserve(best)
+	return best
+}
+
+// shadeColor varies the lightness of c by a key hash, keeping its hue, chroma and alpha.
+// An empty key keeps the color unchanged.
+func shadeColor(c int, key string) int {
+	if key == "" {
+		return c
+	}
+
+	h := uint32(tml.Hash(strings.ToLower(key))) // #nosec G115 -- hash bit pattern
+	dl := shadeSteps[h%uint32(len(shadeSteps))]
+
+	lch := oklabToLCH(argbToOklab(c))
+	shaded := lchToARGB(clamp01(lch[0]+dl), lch[1], lch[2])
+
+	alpha, _, _, _ := argbChannels(c)
+	return int(int32(uint32(alpha)<<24 | uint32(shaded)&0xFFFFFF)) // #nosec G115 -- ARGB bit pattern
+}
+
+// nearest returns the ΔE to the closest used color.
+func (a *colorAllocator) nearest(c oklab) float64 {
+	minD := math.Inf(1)
+	for _, u := range a.used {
+		if d := deltaE(c, u); d < minD {
+			minD = d
+		}
+	}
+
+	return minD
+}
+
+// deltaE returns the OKLab Euclidean distance scaled by 100.
+func deltaE(x, y oklab) float64 {
+	dl, da, db := x.L-y.L, x.A-y.A, x.B-y.B
+	return 100 * math.Sqrt(dl*dl+da*da+db*db)
+}
+
+// argbChannels splits an ARGB color into its channels.
+func argbChannels(c int) (a, r, g, b uint8) {
+	u := uint32(c) // #nosec G115 -- ARGB bit pattern
+	return uint8(u >> 24), uint8(u >> 16), uint8(u >> 8), uint8(u)
+}
+
+// argbToOklab converts an ARGB color to OKLab, ignoring alpha.
+func argbToOklab(c int) oklab {
+	_, r8, g8, b8 := argbChannels(c)
+	r, g, b := srgbToLinear(r8), srgbToLinear(g8), srgbToLinear(b8)
+
+	l := math.Cbrt(0.4122214708*r + 0.5363325363*g + 0.0514459929*b)
+	m := math.Cbrt(0.2119034982*r + 0.6806995451*g + 0.1073969566*b)
+	s := math.Cbrt(0.0883024619*r + 0.2817188376*g + 0.6299787005*b)
+
+	return oklab{
+		L: 0.2104542553*l + 0.7936177850*m - 0.0040720468*s,
+		A: 1.9779984951*l - 2.4285922050*m + 0.4505937099*s,
+		B: 0.0259040371*l + 0.7827717662*m - 0.8086757660*s,
+	}
+}
+
+// oklabToLinear converts OKLab to linear sRGB (may be out of gamut).
+func oklabToLinear(c oklab) (float64, float64, float64) {
+	l := c.L + 0.3963377774*c.A + 0.2158037573*c.B
+	m := c.L - 0.1055613458*c.A - 0.0638541728*c.B
+	s := c.L - 0.0894841775*c.A - 1.2914855480*c.B
+	l, m, s = l*l*l, m*m*m, s*s*s
+
+	return 4.0767416621*l - 3.3077115913*m + 0.2309699292*s,
+		-1.2684380046*l + 2.6097574011*m - 0.3413193965*s,
+		-0.0041960863*l - 0.7034186147*m + 1.7076147010*s
+}
+
+// oklabToLCH converts OKLab to OKLCH (lightness, chroma, hue in degrees).
+func oklabToLCH(c oklab) [3]float64 {
+	h := math.Atan2(c.B, c.A) * 180 / math.Pi
+	if h < 0 {
+		h += 360
+	}
+
+	return [3]float64{c.L, math.Hypot(c.A, c.B), h}
+}
+
+// lchToARGB converts OKLCH to an opaque ARGB color,
+// reducing chroma until the color fits into sRGB.
+func lchToARGB(l, c, h float64) int {
+	rad := h * math.Pi / 180
+	for ; ; c *= 0.9 {
+		r, g, b := oklabToLinear(oklab{L: l, A: c * math.Cos(rad), B: c * math.Sin(rad)})
+		inGamut := r >= 0 && r <= 1 && g >= 0 && g <= 1 && b >= 0 && b <= 1
+		if inGamut || c < 1e-4 {
+			return rgb(linearToSRGB(r), linearToSRGB(g), linearToSRGB(b))
+		}
+	}
+}
+
+// srgbToLinear converts an 8-bit sRGB channel to linear light.
+func srgbToLinear(v uint8) float64 {
+	x := float64(v) / 255
+	if x <= 0.04045 {
+		return x / 12.92
+	}
+
+	return math.Pow((x+0.055)/1.055, 2.4)
+}
+
+// linearToSRGB converts linear light to an 8-bit sRGB channel.
+func linearToSRGB(x float64) int32 {
+	x = clamp01(x)
+	if x <= 0.0031308 {
+		x *= 12.92
+	} else {
+		x = 1.055*math.Pow(x, 1/2.4) - 0.055
+	}
+
+	return int32(math.Round(x * 255))
+}
+
+// clamp01 clamps x to [0, 1].
+func clamp01(x float64) float64 {
+	return math.Max(0, math.Min(1, x))
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestOklabRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	for _, c := range []int{rgb(0, 0, 0), rgb(255, 255, 255), rgb(178, 132, 54), rgb(0, 114, 178)} {
+		lch := oklabToLCH(argbToOklab(c))
+		if got := lchToARGB(lch[0], lch[1], lch[2]); got != c {
+			t.Fatalf("round trip 0x%08X -> 0x%08X", uint32(c), uint32(got))
+		}
+	}
+}
+
+func TestColorAllocatorDeterministic(t *testing.T) {
+	t.Parallel()
+
+	names := []string{"mod_a", "mod_b", "mod_c", "mymod_trees", "mymod_walls"}
+	run := func() []int {
+		a, err := newColorAllocator(paletteOKLCH, 8)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := make([]int, 0, len(names))
+		for _, n := range names {
+			out = append(out, a.fallback(n))
+		}
+		return out
+	}
+
+	first, second := run(), run()
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("fallback(%q) not deterministic: 0x%08X vs 0x%08X", names[i], uint32(first[i]), uint32(second[i]))
+		}
+		for j := 0; j < i; j++ {
+			if d := deltaE(argbToOklab(first[i]), argbToOklab(first[j])); d < 8 {
+				t.Fatalf("%q and %q too close: ΔE=%.2f", names[i], names[j], d)
+			}
+		}
+	}
+}
+
+func TestColorAllocatorCBSafe(t *testing.T) {
+	t.Parallel()
+
+	a, err := newColorAllocator(paletteCBSafe, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	palette := make(map[int]bool, len(okabeIto))
+	for _, c := range okabeIto {
+		palette[c] = true
+	}
+	seen := make(map[int]bool)
+	for i := 0; i < len(okabeIto); i++ {
+		c := a.fallback(fmt.Sprintf("lib_%d", i))
+		if !palette[c] || seen[c] {
+			t.Fatalf("fallback #%d=0x%08X want unused palette color", i, uint32(c))
+		}
+		seen[c] = true
+	}
+}
+
+func TestShadeColorKeepsHue(t *testing.T) {
+	t.Parallel()
+
+	base := rgb(178, 132, 54)
+	if got := shadeColor(base, ""); got != base {
+		t.Fatalf("empty key changed color: 0x%08X", uint32(got))
+	}
+
+	want := oklabToLCH(argbToOklab(base))
+	for _, key := range []string{"barn", "shed", "garage", "silo"} {
+		c := shadeColor(base, key)
+		if a, _, _, _ := argbChannels(c); a != 0xFF {
+			t.Fatalf("shadeColor(%q) alpha=%#x", key, a)
+		}
+		got := oklabToLCH(argbToOklab(c))
+		if d := got[2] - want[2]; d > 5 || d < -5 {
+			t.Fatalf("shadeColor(%q) hue %.1f want ~%.1f", key, got[2], want[2])
+		}
+	}
+}
@@ -2,8 +2,10 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"path"
 	"path/filepath"
+	"regexp"
 	"strings"
 )
 
@@ -39,8 +41,13 @@ func cleanAbs(p string) string {
 		return strings.ToUpper(p[:1]) + `:\`
 	}
 
-	// Cross-platform cleanup
-	return filepath.Clean(p)
+	// Resolve relative paths against the working directory, so they can
+	// be compared with absolute ones.
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return filepath.Clean(p)
+	}
+	return abs
 }
 
 // startsWithPathPrefix checks if a path starts with a prefix.
@@ -54,6 +61,39 @@ func startsWithPathPrefix(path, prefix string) bool {
 	return rel != ".." && !strings.HasPrefix(rel, "../")
 }
 
+// mergeScanRoots drops duplicate scan roots and roots nested inside another root,
+// keeping the order of first appearance. It returns a note for each merged root.
+func mergeScanRoots(roots []string) ([]string, []string) {
+	out := make([]string, 0, len(roots))
+	var notes []string
+	for i, r := range roots {
+		var parent string
+		for j, o := range roots {
+			if i == j || !startsWithPathPrefix(r, o) {
+				continue
+			}
+			// Of equal roots keep the first one.
+			if startsWithPathPrefix(o, r) && j > i {
+				continue
+			}
+			parent = o
+			break
+		}
+
+		if parent == "" {
+			out = append(out, r)
+			continue
+		}
+		if startsWithPathPrefix(parent, r) {
+			notes = append(notes, fmt.Sprintf("scan path %s is given more than once", r))
+		} else {
+			notes = append(notes, fmt.Sprintf("scan path %s is inside %s, merged", r, parent))
+		}
+	}
+
+	return out, notes
+}
+
 // normalizeRelForMatch normalizes a relative path for matching.
 func normalizeRelForMatch(rel string) string {
 	rel = strings.TrimSpace(rel)
@@ -72,9 +112,18 @@ func normalizeRelForMatch(rel string) string {
 	return rel
 }
 
-// buildSkipPrefixes builds skip prefixes for matching.
-func buildSkipPrefixes(gameRoot string, skip []string) ([]string, error) {
-	out := make([]string, 0, len(skip))
+// skipRule is a compiled --skip pattern with the paths it skipped.
+type skipRule struct {
+	re      *regexp.Regexp
+	pattern string   // normalized pattern
+	skipped []string // skipped paths relative to game-root, dirs end with '/'
+}
+
+// buildSkipRules compiles skip patterns for matching.
+// A plain pattern matches whole path segments, "prefix*" and other
+// globs match by glob; a match also skips everything below it.
+func buildSkipRules(gameRoot string, skip []string) ([]*skipRule, error) {
+	out := make([]*skipRule, 0, len(skip))
 	for _, s := range skip {
 		s = strings.TrimSpace(s)
 		if s == "" {
@@ -99,38 +148,73 @@ func buildSkipPrefixes(gameRoot string, skip []string) ([]string, error) {
 		if s == "" {
 			continue
 		}
-		out = append(out, strings.ToLower(s))
+
+		expr, err := globToRegexp(s)
+		if err != nil {
+			return nil, fmt.Errorf("bad skip pattern: %w", err)
+		}
+		re, err := regexp.Compile("(?i)^" + expr + "(?:/.*)?$")
+		if err != nil {
+			return nil, fmt.Errorf("bad skip pattern %q: %w", s, err)
+		}
+
+		out = append(out, &skipRule{pattern: strings.ToLower(s), re: re})
 	}
 
 	return out, nil
 }
 
-// matchSkip checks if a path matches a skip prefix.
-func matchSkip(rel string, prefixes []string) bool {
-	if len(prefixes) == 0 {
-		return false
+// cloneSkipRules returns copies of rules without skipped paths.
+func cloneSkipRules(rules []*skipRule) []*skipRule {
+	out := make([]*skipRule, len(rules))
+	for i, r := range rules {
+		out[i] = &skipRule{re: r.re, pattern: r.pattern}
+	}
+
+	return out
+}
+
+// matchSkip returns the first skip rule matching a path, or nil.
+func matchSkip(rel string, rules []*skipRule) *skipRule {
+	if len(rules) == 0 {
+		return nil
 	}
 
 	rel = normalizeRelForMatch(rel)
 	if rel == "" {
-		return false
+		return nil
 	}
 
-	rel = strings.ToLower(rel)
-	for _, p := range prefixes {
-		if strings.HasSuffix(p, "*") {
-			base := strings.TrimSuffix(p, "*")
-			if base != "" && strings.HasPrefix(rel, base) {
-				return true
-			}
-
-			continue
+	for _, r := range rules {
+		if r.re.MatchString(rel) {
+			return r
 		}
+	}
 
-		if rel == p || strings.HasPrefix(rel, p+"/") || strings.HasPrefix(rel, p) {
-			return true
+	return nil
+}
+
+// skippedPath formats a skipped path relative to game-root, dirs end with '/'.
+func skippedPath(rel string, dir bool) string {
+	rel = filepath.ToSlash(rel)
+	if dir {
+		rel += "/"
+	}
+	return rel
+}
+
+// writeSkipReport writes skipped paths per rule as TSV.
+func writeSkipReport(w io.Writer, rules []*skipRule) error {
+	if _, err := fmt.Fprintln(w, "rule\tpath"); err != nil {
+		return err
+	}
+	for _, r := range rules {
+		for _, p := range r.skipped {
+			if _, err := fmt.Fprintf(w, "%s\t%s\n", r.pattern, p); err != nil {
+				return err
+			}
 		}
 	}
 
-	return false
+	return nil
 }
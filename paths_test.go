@@ -0,0 +1,78 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMatchSkipSegments(t *testing.T) {
+	t.Parallel()
+
+	rules, err := buildSkipRules("/game", []string{"data", "gear", "dz/sounds*", "**/proxy", "/game/dz/test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		rel  string
+		want string
+	}{
+		{"data", "data"},
+		{"Data/x.p3d", "data"},
+		{"database", ""},
+		{"datacenter/a", ""},
+		{"data_center", ""},
+		{"gears_factory", ""},
+		{"gear/tools", "gear"},
+		{"dz/sounds_env/a", "dz/sounds*"},
+		{"dz/structures/proxy/a.p3d", "**/proxy"},
+		{"dz/structures/proxyish", ""},
+		{"dz/test/a", "dz/test"},
+		{"dz/tests", ""},
+	}
+	for _, tc := range cases {
+		got := ""
+		if r := matchSkip(tc.rel, rules); r != nil {
+			got = r.pattern
+		}
+		if got != tc.want {
+			t.Fatalf("matchSkip(%q) = %q want %q", tc.rel, got, tc.want)
+		}
+	}
+
+	if _, err := buildSkipRules("/game", []string{"/other/data"}); err == nil {
+		t.Fatal("skip outside game-root should fail")
+	}
+}
+
+func TestWriteSkipReport(t *testing.T) {
+	t.Parallel()
+
+	rules, err := buildSkipRules("/game", []string{"data"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules[0].skipped = append(rules[0].skipped, skippedPath("dz/data", true), skippedPath("dz/data.p3d", false))
+
+	var b strings.Builder
+	if err := writeSkipReport(&b, rules); err != nil {
+		t.Fatal(err)
+	}
+	want := "rule\tpath\ndata\tdz/data/\ndata\tdz/data.p3d\n"
+	if b.String() != want {
+		t.Fatalf("report %q want %q", b.String(), want)
+	}
+}
+
+func TestMergeScanRoots(t *testing.T) {
+	t.Parallel()
+
+	roots, notes := mergeScanRoots([]string{"/g/dz/structures", "/g/dz", "/g/dz", "/g/dzx", "/g/dz/plants"})
+	want := []string{"/g/dz", "/g/dzx"}
+	if strings.Join(roots, ",") != strings.Join(want, ",") {
+		t.Fatalf("roots %v want %v", roots, want)
+	}
+	if len(notes) != 3 {
+		t.Fatalf("got %d notes want 3: %v", len(notes), notes)
+	}
+}
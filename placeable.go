@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+// Special LOD resolutions.
+const (
+	lodGeometry     = 1e13
+	lodViewGeometry = 6e15
+)
+
+// Reasons a model is not placeable.
+const (
+	reasonNoResolution = "no resolution LOD"
+	reasonNoGeometry   = "no geometry or view geometry LOD"
+	reasonClass        = "class property"
+)
+
+// nonPlaceableClasses are values of the "class" named property
+// for models that are not static map objects.
+var nonPlaceableClasses = map[string]bool{
+	"man":        true,
+	"car":        true,
+	"tank":       true,
+	"helicopter": true,
+	"plane":      true,
+	"ship":       true,
+	"proxy":      true,
+}
+
+// isLOD reports whether a resolution is the given special LOD.
+func isLOD(res float32, lod float64) bool {
+	return math.Abs(float64(res)-lod) <= lod*1e-4
+}
+
+// unplaceableReason explains why a model cannot be placed as a map object,
+// or returns "" when it can.
+func unplaceableReason(info *P3DInfo) string {
+	resolution, geometry := false, false
+	for _, res := range info.LODs {
+		switch {
+		case res < p3dSpecialLOD:
+			resolution = true
+		case isLOD(res, lodGeometry), isLOD(res, lodViewGeometry):
+			geometry = true
+		}
+	}
+
+	if !resolution {
+		return reasonNoResolution
+	}
+	if !geometry {
+		return reasonNoGeometry
+	}
+	if class := strings.ToLower(strings.TrimSpace(info.Properties["class"])); nonPlaceableClasses[class] {
+		return reasonClass + " " + class
+	}
+
+	return ""
+}
+
+// skippedModel is a model excluded as non-placeable.
+type skippedModel struct {
+	RelPath string
+	Reason  string
+}
+
+// writeSkippedModels writes excluded models as TSV.
+func writeSkippedModels(w io.Writer, models []skippedModel) error {
+	if _, err := fmt.Fprintln(w, "path\treason"); err != nil {
+		return err
+	}
+	for _, m := range models {
+		if _, err := fmt.Fprintf(w, "%s\t%s\n", m.RelPath, m.Reason); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import "testing"
+
+func TestUnplaceableReason(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name  string
+		lods  []float32
+		props map[string]string
+		want  string
+	}{
+		{"house", []float32{1, 2, 1e13, 1e15, 6e15}, map[string]string{"class": "house"}, ""},
+		{"view geometry only", []float32{1, 6e15}, nil, ""},
+		{"memory helper", []float32{1e15}, nil, reasonNoResolution},
+		{"proxy", []float32{1, 2}, nil, reasonNoGeometry},
+		{"vehicle", []float32{1, 1e13}, map[string]string{"class": "Car"}, reasonClass + " car"},
+	}
+	for _, tc := range cases {
+		got := unplaceableReason(&P3DInfo{LODs: tc.lods, Properties: tc.props})
+		if got != tc.want {
+			t.Fatalf("%s: reason %q want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestParseMLODProperties(t *testing.T) {
+	t.Parallel()
+
+	var b p3dBuilder
+	b.WriteString("MLOD")
+	b.u32(0x101)
+	b.u32(2)
+	b.mlodLod([][3]float32{{0, 0, 0}, {1, 1, 1}}, 1)
+	b.mlodLod([][3]float32{{0, 0, 0}}, 1e13, [2]string{"Class", "house"}, [2]string{"map", "building"})
+
+	info, err := parseP3D(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Properties["class"] != "house" || info.Properties["map"] != "building" {
+		t.Fatalf("unexpected properties: %v", info.Properties)
+	}
+	if got := unplaceableReason(info); got != "" {
+		t.Fatalf("house should be placeable, got %q", got)
+	}
+}
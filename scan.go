@@ -0,0 +1,440 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// scanCacheVersion is bumped when the cache layout or P3DInfo changes.
+const scanCacheVersion = 3
+
+// Entries modified within this window are not cached, a change in the
+// same mtime tick would otherwise go unnoticed.
+const scanCacheRacyWindow = 2 * time.Second
+
+// dirListing is a cached directory listing.
+type dirListing struct {
+	Dirs    []string // subdirectory names
+	Files   []string // regular file and other entry names
+	Links   []string // symlink and junction names
+	ModTime int64    // directory mtime in ns
+}
+
+// cachedModel is cached model metadata.
+type cachedModel struct {
+	Info    *P3DInfo
+	Size    int64 // file size
+	ModTime int64 // file mtime in ns
+}
+
+// scanCacheFile is the on-disk cache layout.
+type scanCacheFile struct {
+	Dirs    map[string]*dirListing  // by absolute directory path
+	Models  map[string]*cachedModel // by absolute model path
+	Version int
+}
+
+// scanCache reuses directory listings whose mtime did not change and
+// model metadata whose size and mtime did not change.
+// A nil cache reads everything from disk.
+type scanCache struct {
+	dirs       map[string]*dirListing
+	models     map[string]*cachedModel
+	usedDirs   map[string]struct{}
+	usedModels map[string]struct{}
+	path       string
+	mu         sync.Mutex
+}
+
+// defaultScanCachePath returns the per game-root cache file in the user cache dir.
+func defaultScanCachePath(gameRoot string) (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(scanCacheKey(gameRoot, runtime.GOOS)))
+	return filepath.Join(dir, "tml-gen", fmt.Sprintf("scan-%016x.gob.gz", h.Sum64())), nil
+}
+
+// scanCacheKey returns the game-root as hashed for the cache file name,
+// folded to lowercase only where file systems are case-insensitive.
+func scanCacheKey(gameRoot, goos string) string {
+	switch goos {
+	case "windows", "darwin":
+		return strings.ToLower(gameRoot)
+	default:
+		return gameRoot
+	}
+}
+
+// loadScanCache reads a cache file. A missing or outdated file gives an empty
+// cache, a corrupt one gives an empty cache and the error.
+func loadScanCache(path string, rebuild bool) (*scanCache, error) {
+	c := &scanCache{
+		path:       path,
+		dirs:       make(map[string]*dirListing),
+		models:     make(map[string]*cachedModel),
+		usedDirs:   make(map[string]struct{}),
+		usedModels: make(map[string]struct{}),
+	}
+	if rebuild {
+		return c, nil
+	}
+
+	f, err := os.Open(path) // #nosec G304 -- cache path from options
+	if errors.Is(err, fs.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return c, err
+	}
+	defer func() { _ = f.Close() }()
+
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return c, fmt.Errorf("scan cache %s: %w", path, err)
+	}
+	var file scanCacheFile
+	if err := gob.NewDecoder(zr).Decode(&file); err != nil {
+		return c, fmt.Errorf("scan cache %s: %w", path, err)
+	}
+	if file.Version != scanCacheVersion {
+		return c, nil
+	}
+
+	if file.Dirs != nil {
+		c.dirs = file.Dirs
+	}
+	if file.Models != nil {
+		c.models = file.Models
+	}
+
+	return c, nil
+}
+
+// save drops directory listings under the walked roots that were not seen
+// in this run and models no longer listed there, then writes the cache
+// atomically.
+func (c *scanCache) save(roots []string) error {
+	if c == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	under := func(path string) bool {
+		for _, r := range roots {
+			if startsWithPathPrefix(path, r) {
+				return true
+			}
+		}
+		return false
+	}
+	for path := range c.dirs {
+		if _, ok := c.usedDirs[path]; !ok && under(path) {
+			delete(c.dirs, path)
+		}
+	}
+	// Runs without --placeable-only or --shape-from-model read no models,
+	// so metadata is only dropped for files gone from their directory.
+	listed := func(path string) bool {
+		l := c.dirs[filepath.Dir(path)]
+		if l == nil {
+			return false
+		}
+		name := filepath.Base(path)
+		i := sort.SearchStrings(l.Files, name)
+		return i < len(l.Files) && l.Files[i] == name
+	}
+	for path := range c.models {
+		if _, ok := c.usedModels[path]; !ok && under(path) && !listed(path) {
+			delete(c.models, path)
+		}
+	}
+	// Start counting usage afresh for the next run.
+	clear(c.usedDirs)
+	clear(c.usedModels)
+
+	if err := os.MkdirAll(filepath.Dir(c.path), 0o750); err != nil {
+		return err
+	}
+
+	file := scanCacheFile{Version: scanCacheVersion, Dirs: c.dirs, Models: c.models}
+	return writeFileAtomic(c.path, func(w io.Writer) error {
+		zw := gzip.NewWriter(w)
+		if err := gob.NewEncoder(zw).Encode(&file); err != nil {
+			return err
+		}
+		return zw.Close()
+	})
+}
+
+// stable reports whether an mtime is old enough to be trusted.
+func stable(mod time.Time) bool {
+	return time.Since(mod) > scanCacheRacyWindow
+}
+
+// readDir lists a directory, sorted by name.
+func (c *scanCache) readDir(dir string) (*dirListing, error) {
+	var mod time.Time
+	if c != nil {
+		st, err := os.Stat(dir)
+		if err != nil {
+			return nil, err
+		}
+		mod = st.ModTime()
+
+		c.mu.Lock()
+		l := c.dirs[dir]
+		if l != nil && l.ModTime == mod.UnixNano() {
+			c.usedDirs[dir] = struct{}{}
+			c.mu.Unlock()
+			return l, nil
+		}
+		c.mu.Unlock()
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &dirListing{ModTime: mod.UnixNano()}
+	for _, e := range entries {
+		switch {
+		case e.IsDir():
+			l.Dirs = append(l.Dirs, e.Name())
+		case e.Type()&(fs.ModeSymlink|fs.ModeIrregular) != 0:
+			// Junctions on Windows are reported as irregular files.
+			l.Links = append(l.Links, e.Name())
+		default:
+			l.Files = append(l.Files, e.Name())
+		}
+	}
+
+	if c != nil && stable(mod) {
+		c.mu.Lock()
+		c.dirs[dir] = l
+		c.usedDirs[dir] = struct{}{}
+		c.mu.Unlock()
+	}
+
+	return l, nil
+}
+
+// modelInfo returns model metadata, reading the file only when it changed.
+func (c *scanCache) modelInfo(path string) (*P3DInfo, error) {
+	if c == nil {
+		return readP3DInfo(path)
+	}
+
+	st, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	m := c.models[path]
+	if m != nil && m.Size == st.Size() && m.ModTime == st.ModTime().UnixNano() {
+		c.usedModels[path] = struct{}{}
+		c.mu.Unlock()
+		return m.Info, nil
+	}
+	c.mu.Unlock()
+
+	info, err := readP3DInfo(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if stable(st.ModTime()) {
+		c.mu.Lock()
+		c.models[path] = &cachedModel{Info: info, Size: st.Size(), ModTime: st.ModTime().UnixNano()}
+		c.usedModels[path] = struct{}{}
+		c.mu.Unlock()
+	}
+
+	return info, nil
+}
+
+// scanRoot is a scan path inside one layer.
+type scanRoot struct {
+	path  string // absolute path
+	rel   string // relative to the layer root
+	start string // directory below path to walk from, "" for path
+	layer int    // index into the layer roots
+}
+
+// skipRecord is a path skipped by a skip rule.
+type skipRecord struct {
+	rule *skipRule
+	path string
+}
+
+// scanShard holds results of a single walk worker, merged after the walk.
+type scanShard struct {
+	hits    []scanHit
+	skipped []skipRecord
+	_       [64]byte // keep shards on separate cache lines
+}
+
+// scanner walks scan paths in all layers applying skip, ignore and filter rules.
+type scanner struct {
+	cache     *scanCache
+	errs      *errorLog // nil aborts on the first error
+	filter    *fileFilter
+	layers    []string      // game-root followed by overlay layers
+	ignores   []*ignoreTree // per layer, nil when ignore files are disabled
+	skipRules []*skipRule
+	workers   int
+	follow    bool // follow symlinks and junctions
+
+	// Watchers only look for directories and models a run would scan:
+	// they call onDir for each such directory, report no progress
+	// and skip malformed ignore files like unreadable ones.
+	onDir func(path string) error
+	watch bool
+}
+
+// scan walks all roots in parallel and returns model hits sorted by path and layer.
+func (s *scanner) scan(roots []scanRoot) ([]scanHit, error) {
+	paths := make([]string, len(roots))
+	for i, r := range roots {
+		paths[i] = r.path
+		if r.start != "" {
+			paths[i] = r.start
+		}
+	}
+
+	// Counts are per scan.
+	for _, r := range s.skipRules {
+		r.skipped = nil
+	}
+	s.filter.reset()
+
+	shards := make([]scanShard, max(s.workers, 1))
+	wk := &walker{cache: s.cache, workers: s.workers, follow: s.follow, onErr: s.errs.add}
+	err := wk.walk(paths, func(w, root int, path string, dir bool) error {
+		return s.visit(&shards[w], roots[root], path, dir)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Merge worker results in a fixed order.
+	var hits []scanHit
+	for i := range shards {
+		hits = append(hits, shards[i].hits...)
+		for _, sk := range shards[i].skipped {
+			sk.rule.skipped = append(sk.rule.skipped, sk.path)
+		}
+	}
+	for _, r := range s.skipRules {
+		sort.Strings(r.skipped)
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].layer != hits[j].layer {
+			return hits[i].layer < hits[j].layer
+		}
+		return hits[i].rel < hits[j].rel
+	})
+
+	return hits, nil
+}
+
+// visit applies skip, ignore and filter rules to a single entry.
+func (s *scanner) visit(sh *scanShard, root scanRoot, path string, dir bool) error {
+	layerRoot := s.layers[root.layer]
+
+	// Compute both relative paths: to game-root (or layer) and to the scan-root.
+	relGame, err := filepath.Rel(layerRoot, path)
+	if err != nil {
+		return skipEntry(dir)
+	}
+	relScan, err := filepath.Rel(root.path, path)
+	if err != nil {
+		return skipEntry(dir)
+	}
+
+	relGameTrimmed := ""
+	if segs := splitSegs(relGame); len(segs) > 1 {
+		relGameTrimmed = strings.Join(segs[1:], "/")
+	}
+	rule := matchSkip(relScan, s.skipRules)
+	if rule == nil {
+		rule = matchSkip(relGame, s.skipRules)
+	}
+	if rule == nil {
+		rule = matchSkip(relGameTrimmed, s.skipRules)
+	}
+	if rule != nil {
+		slog.Debug("skip", "rule", rule.pattern, "path", relGame)
+		sh.skipped = append(sh.skipped, skipRecord{rule: rule, path: skippedPath(relGame, dir)})
+		return skipEntry(dir)
+	}
+
+	// Honour .tmlignore files between the scan-root and the entry.
+	var ignores *ignoreTree
+	if s.ignores != nil {
+		ignores = s.ignores[root.layer]
+	}
+	ignored, err := ignores.ignored(root.rel, relGame, dir)
+	var se *ignoreSyntaxError
+	if errors.As(err, &se) && !s.watch {
+		return err
+	}
+	if err != nil {
+		if err := s.errs.add(path, err); err != nil {
+			return err
+		}
+	}
+	if ignored {
+		slog.Debug("ignore", "path", relGame)
+		return skipEntry(dir)
+	}
+
+	// Only keep files picked by the include/exclude rules.
+	if dir {
+		if s.onDir != nil {
+			if err := s.onDir(path); err != nil {
+				return err
+			}
+		}
+		if !s.watch {
+			progressLine.addDir()
+		}
+		return nil
+	}
+	if s.filter.match(filepath.ToSlash(relGame)) {
+		if !s.watch {
+			progressLine.addModel()
+		}
+		sh.hits = append(sh.hits, scanHit{rel: filepath.ToSlash(relGame), layer: root.layer})
+	}
+
+	return nil
+}
+
+// skipEntry returns fs.SkipDir for directories and nil for files.
+func skipEntry(dir bool) error {
+	if dir {
+		return fs.SkipDir
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// walkFiles returns files found by a walker relative to root.
+func walkFiles(t *testing.T, root string, c *scanCache) []string {
+	t.Helper()
+
+	return walkWith(t, root, &walker{cache: c, workers: 4})
+}
+
+// walkWith returns files found by wk relative to root.
+func walkWith(t *testing.T, root string, wk *walker) []string {
+	t.Helper()
+
+	found := make([][]string, max(wk.workers, 1))
+	err := wk.walk([]string{root}, func(w, _ int, path string, dir bool) error {
+		if dir && filepath.Base(path) == "skip" {
+			return fs.SkipDir
+		}
+		if !dir {
+			rel, _ := filepath.Rel(root, path)
+			found[w] = append(found[w], filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out []string
+	for _, f := range found {
+		out = append(out, f...)
+	}
+	slices.Sort(out)
+	return out
+}
+
+func TestScanCacheReusesUnchangedDirs(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	old := time.Now().Add(-time.Hour)
+	for _, rel := range []string{"a/x.p3d", "a/b/y.p3d", "skip/z.p3d"} {
+		p := filepath.Join(root, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(p), 0o750); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, rel := range []string{"a/b", "a", "skip", "."} {
+		if err := os.Chtimes(filepath.Join(root, rel), old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cachePath := filepath.Join(t.TempDir(), "scan.gob.gz")
+	c, err := loadScanCache(cachePath, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"a/b/y.p3d", "a/x.p3d"}
+	if got := walkFiles(t, root, c); !slices.Equal(got, want) {
+		t.Fatalf("files %v want %v", got, want)
+	}
+	if err := c.save([]string{root}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A new file with the old directory mtime restored stays invisible to the cache.
+	if err := os.WriteFile(filepath.Join(root, "a", "new.p3d"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(filepath.Join(root, "a"), old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err = loadScanCache(cachePath, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := walkFiles(t, root, c); !slices.Equal(got, want) {
+		t.Fatalf("cached files %v want %v", got, want)
+	}
+
+	// A rebuilt cache reads the directory again.
+	c, err = loadScanCache(cachePath, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []string{"a/b/y.p3d", "a/new.p3d", "a/x.p3d"}
+	if got := walkFiles(t, root, c); !slices.Equal(got, want) {
+		t.Fatalf("rebuilt files %v want %v", got, want)
+	}
+
+	// Without a cache everything is read from disk.
+	if got := walkFiles(t, root, nil); !slices.Equal(got, want) {
+		t.Fatalf("uncached files %v want %v", got, want)
+	}
+}
+
+func TestScanCacheModelInfo(t *testing.T) {
+	t.Parallel()
+
+	var b p3dBuilder
+	b.WriteString("MLOD")
+	b.u32(0x101)
+	b.u32(1)
+	b.mlodLod([][3]float32{{0, 0, 0}, {1, 1, 1}}, 1)
+
+	path := filepath.Join(t.TempDir(), "m.p3d")
+	if err := os.WriteFile(path, b.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(path, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	c, _ := loadScanCache(filepath.Join(t.TempDir(), "scan.gob.gz"), false)
+	info, err := c.modelInfo(path)
+	if err != nil || len(info.LODs) != 1 {
+		t.Fatalf("modelInfo: %+v %v", info, err)
+	}
+	cached, err := c.modelInfo(path)
+	if err != nil || cached != info {
+		t.Fatal("unchanged model should come from the cache")
+	}
+}
+
+func TestScanCacheKey(t *testing.T) {
+	t.Parallel()
+
+	if scanCacheKey("/srv/Game", "linux") == scanCacheKey("/srv/game", "linux") {
+		t.Fatal("case-different roots share a cache on linux")
+	}
+	for _, goos := range []string{"windows", "darwin"} {
+		if scanCacheKey(`P:\Game`, goos) != scanCacheKey(`p:\game`, goos) {
+			t.Fatalf("case-different roots use different caches on %s", goos)
+		}
+	}
+}
+
+func TestScannerDeterministic(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	var want []string
+	for d := 0; d < 8; d++ {
+		for f := 0; f < 8; f++ {
+			rel := filepath.ToSlash(filepath.Join("dz", "s"+string(rune('a'+d)), "m"+string(rune('a'+f))+".p3d"))
+			p := filepath.Join(root, filepath.FromSlash(rel))
+			if err := os.MkdirAll(filepath.Dir(p), 0o750); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(p, nil, 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if d != 3 {
+				want = append(want, rel)
+			}
+		}
+	}
+	slices.Sort(want)
+
+	rules, err := buildSkipRules(root, []string{"sd"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for run := 0; run < 3; run++ {
+		for _, r := range rules {
+			r.skipped = nil
+		}
+		sc := &scanner{filter: &fileFilter{}, layers: []string{root}, skipRules: rules, workers: 8}
+		hits, err := sc.scan([]scanRoot{{path: filepath.Join(root, "dz"), rel: "dz"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got := make([]string, 0, len(hits))
+		for _, h := range hits {
+			got = append(got, h.rel)
+		}
+		if !slices.Equal(got, want) {
+			t.Fatalf("run %d: hits %v want %v", run, got, want)
+		}
+		if !slices.Equal(rules[0].skipped, []string{"dz/sd/"}) {
+			t.Fatalf("skipped %v", rules[0].skipped)
+		}
+	}
+}
@@ -0,0 +1,442 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// defaultThemeName is the name of the built-in theme.
+const defaultThemeName = "default"
+
+// ThemeFile is the on-disk theme file format with several named themes.
+type ThemeFile struct {
+	Themes map[string]ThemeSpec `json:"themes"`
+}
+
+// ThemeSpec is an ordered list of style rules; the first match wins.
+type ThemeSpec struct {
+	Rules []ThemeRule `json:"rules"`
+	// Icons picks per-template cells of the library texture atlas.
+	Icons []IconRule `json:"icons,omitempty"`
+}
+
+// IconRule maps models to a cell of the library texture atlas.
+type IconRule struct {
+	// Pattern is a glob matched against the lowercase model file name,
+	// or against the model path relative to game root when it contains '/'.
+	Pattern string `json:"pattern"`
+	// UV is the atlas cell: lower-left U, V and upper-right U, V.
+	UV [4]float64 `json:"uv"`
+}
+
+// ThemeRule maps library tokens or a group path pattern to a style.
+type ThemeRule struct {
+	// Tokens must all be present in the library key split by '_'.
+	// Every token after the first must follow the first one.
+	Tokens []string `json:"tokens,omitempty"`
+	// Pattern is a glob matched against the lowercase group path ("dz/structures/*").
+	Pattern string `json:"pattern,omitempty"`
+	// Fill is "#RRGGBB", "#AARRGGBB" or "r,g,b"; empty leaves the fill to later rules.
+	Fill string `json:"fill,omitempty"`
+	// Outline is a color, "auto" (derived from subgroup tokens) or "none".
+	Outline string `json:"outline,omitempty"`
+	// Shape is "ellipse" or "rectangle"; empty leaves the shape to later rules.
+	Shape string `json:"shape,omitempty"`
+	// Tex is the library icon texture (atlas); empty leaves it to later rules.
+	Tex string `json:"tex,omitempty"`
+	// UV is the default atlas cell for templates: lower-left U, V and upper-right U, V.
+	UV *[4]float64 `json:"uv,omitempty"`
+}
+
+// builtinThemes holds themes shipped with the binary.
+var builtinThemes = map[string]ThemeSpec{
+	defaultThemeName: {Rules: []ThemeRule{
+		// Water: blue for rivers, turquoise for ponds.
+		{Tokens: []string{"water", "pond"}, Fill: "34,160,170", Outline: "auto"},
+		{Tokens: []string{"water", "ponds"}, Fill: "34,160,170", Outline: "auto"},
+		{Tokens: []string{"water", "river"}, Fill: "35,90,190", Outline: "auto"},
+		{Tokens: []string{"water"}, Fill: "45,112,197", Outline: "auto"},
+
+		// Structures: industry/residential/etc.
+		{Tokens: []string{"structures", "industrial"}, Fill: "178,132,54", Outline: "auto"},
+		{Tokens: []string{"structures", "residential"}, Fill: "196,178,146", Outline: "auto"},
+		{Tokens: []string{"structures", "military"}, Fill: "163,41,41", Outline: "auto"},
+		{Tokens: []string{"structures", "roads"}, Fill: "68,53,85", Outline: "auto"},
+		{Tokens: []string{"structures", "road"}, Fill: "68,53,85", Outline: "auto"},
+		{Tokens: []string{"structures", "rail"}, Fill: "107,43,99", Outline: "auto"},
+		{Tokens: []string{"structures", "ruins"}, Fill: "92,86,82", Outline: "auto"},
+		{Tokens: []string{"structures", "walls"}, Fill: "122,122,90", Outline: "auto"},
+		{Tokens: []string{"structures", "wrecks"}, Fill: "83,41,14", Outline: "auto"},
+		{Tokens: []string{"structures", "signs"}, Fill: "212,40,175", Outline: "auto"},
+		{Tokens: []string{"structures", "furniture"}, Fill: "140,110,80", Outline: "auto"},
+		{Tokens: []string{"structures", "underground"}, Fill: "90,96,110", Outline: "auto"},
+		{Tokens: []string{"structures"}, Fill: "150,150,150", Outline: "auto"},
+
+		// Nature and terrain groups.
+		{Tokens: []string{"plants"}, Fill: "78,140,74", Outline: "auto", Shape: "ellipse"},
+		{Tokens: []string{"rocks"}, Fill: "120,110,100", Outline: "auto", Shape: "ellipse"},
+		{Tokens: []string{"surfaces"}, Fill: "165,147,111", Outline: "auto"},
+		{Tokens: []string{"worlds"}, Fill: "90,110,140", Outline: "auto"},
+	}},
+}
+
+// outlineMode tells how a rule sets the outline color.
+type outlineMode int
+
+const (
+	outlineNone  outlineMode = iota // default outline (no color)
+	outlineAuto                     // derived from subgroup tokens
+	outlineFixed                    // fixed color
+)
+
+// defaultUV covers the whole texture.
+var defaultUV = [4]float64{0, 0, 1, 1}
+
+// themeRule is a compiled ThemeRule.
+type themeRule struct {
+	tokens      []string
+	pattern     string
+	shape       string
+	tex         string
+	uv          [4]float64
+	fill        int
+	outline     int
+	outlineMode outlineMode
+	hasFill     bool
+}
+
+// theme is a compiled ThemeSpec.
+type theme struct {
+	name     string
+	rules    []themeRule
+	icons    []IconRule
+	explicit bool // loaded from a user theme file
+}
+
+// defaultTheme is the compiled built-in theme.
+var defaultTheme = mustCompileTheme(defaultThemeName, builtinThemes[defaultThemeName])
+
+// mustCompileTheme compiles a built-in theme and panics on errors.
+func mustCompileTheme(name string, spec ThemeSpec) *theme {
+	th, err := compileTheme(name, spec)
+	if err != nil {
+		panic(err)
+	}
+
+	return th
+}
+
+// compileTheme validates and compiles a theme spec.
+func compileTheme(name string, spec ThemeSpec) (*theme, error) {
+	th := &theme{name: name, rules: make([]themeRule, 0, len(spec.Rules))}
+	for i, r := range spec.Rules {
+		cr := themeRule{pattern: strings.ToLower(strings.TrimSpace(r.Pattern))}
+		for _, t := range r.Tokens {
+			t = strings.ToLower(strings.TrimSpace(t))
+			if t != "" {
+				cr.tokens = append(cr.tokens, t)
+			}
+		}
+		if len(cr.tokens) == 0 && cr.pattern == "" {
+			return nil, fmt.Errorf("theme %s rule %d: tokens or pattern required", name, i+1)
+		}
+		if cr.pattern != "" {
+			if _, err := path.Match(cr.pattern, ""); err != nil {
+				return nil, fmt.Errorf("theme %s rule %d: bad pattern %q: %w", name, i+1, r.Pattern, err)
+			}
+		}
+
+		if r.Fill != "" {
+			c, err := parseColor(r.Fill)
+			if err != nil {
+				return nil, fmt.Errorf("theme %s rule %d: fill: %w", name, i+1, err)
+			}
+			cr.fill, cr.hasFill = c, true
+		}
+
+		switch o := strings.ToLower(strings.TrimSpace(r.Outline)); o {
+		case "", "none":
+			cr.outlineMode = outlineNone
+		case "auto":
+			cr.outlineMode = outlineAuto
+		default:
+			c, err := parseColor(o)
+			if err != nil {
+				return nil, fmt.Errorf("theme %s rule %d: outline: %w", name, i+1, err)
+			}
+			cr.outline, cr.outlineMode = c, outlineFixed
+		}
+
+		switch s := strings.ToLower(strings.TrimSpace(r.Shape)); s {
+		case "", "ellipse", "rectangle":
+			cr.shape = s
+		default:
+			return nil, fmt.Errorf("theme %s rule %d: bad shape %q", name, i+1, r.Shape)
+		}
+
+		cr.tex = strings.TrimSpace(r.Tex)
+		cr.uv = defaultUV
+		if r.UV != nil {
+			if err := validUV(*r.UV); err != nil {
+				return nil, fmt.Errorf("theme %s rule %d: %w", name, i+1, err)
+			}
+			cr.uv = *r.UV
+		}
+
+		th.rules = append(th.rules, cr)
+	}
+
+	for i, ic := range spec.Icons {
+		ic.Pattern = strings.ToLower(strings.TrimSpace(ic.Pattern))
+		if _, err := path.Match(ic.Pattern, ""); err != nil || ic.Pattern == "" {
+			return nil, fmt.Errorf("theme %s icon %d: bad pattern %q", name, i+1, ic.Pattern)
+		}
+		if err := validUV(ic.UV); err != nil {
+			return nil, fmt.Errorf("theme %s icon %d: %w", name, i+1, err)
+		}
+		th.icons = append(th.icons, ic)
+	}
+
+	return th, nil
+}
+
+// validUV checks that an atlas cell lies within the texture.
+func validUV(uv [4]float64) error {
+	for _, v := range uv {
+		if v < 0 || v > 1 {
+			return fmt.Errorf("uv %v out of [0, 1]", uv)
+		}
+	}
+	if uv[0] >= uv[2] || uv[1] >= uv[3] {
+		return fmt.Errorf("uv %v: lower-left must be below upper-right", uv)
+	}
+
+	return nil
+}
+
+// match reports whether the rule matches and returns the anchor token index.
+func (r *themeRule) match(tokens []string, groupPath string) (int, bool) {
+	if r.pattern != "" {
+		ok, _ := path.Match(r.pattern, groupPath)
+		if !ok {
+			return -1, false
+		}
+	}
+	if len(r.tokens) == 0 {
+		return -1, true
+	}
+
+	idx := -1
+	for i := range tokens {
+		if tokens[i] == r.tokens[0] {
+			idx = i
+			break
+		}
+	}
+	if idx == -1 {
+		return -1, false
+	}
+
+	for _, want := range r.tokens[1:] {
+		found := false
+		for i := idx + 1; i < len(tokens); i++ {
+			if tokens[i] == want {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return -1, false
+		}
+	}
+
+	return idx, true
+}
+
+// colors selects fill/outline for a library key and its group path.
+// It reports false when no rule matches and a fallback color is needed.
+func (th *theme) colors(key string, groupPath string) (int, int, bool) {
+	tokens := strings.Split(strings.ToLower(key), "_")
+	groupPath = strings.ToLower(groupPath)
+
+	for i := range th.rules {
+		r := &th.rules[i]
+		if !r.hasFill {
+			continue
+		}
+		idx, ok := r.match(tokens, groupPath)
+		if !ok {
+			continue
+		}
+
+		switch r.outlineMode {
+		case outlineFixed:
+			return r.fill, r.outline, true
+		case outlineAuto:
+			sub := strings.Join(tokens, "_")
+			if idx >= 0 {
+				sub = ""
+				if idx+1 < len(tokens) {
+					sub = strings.Join(tokens[idx+1:], "_")
+				}
+			}
+			return r.fill, outlineForKey(sub), true
+		default:
+			return r.fill, defaultOutline, true
+		}
+	}
+
+	return 0, defaultOutline, false
+}
+
+// shape returns the first configured shape for a library, or "".
+func (th *theme) shape(key string, groupPath string) string {
+	tokens := strings.Split(strings.ToLower(key), "_")
+	groupPath = strings.ToLower(groupPath)
+
+	for i := range th.rules {
+		r := &th.rules[i]
+		if r.shape == "" {
+			continue
+		}
+		if _, ok := r.match(tokens, groupPath); ok {
+			return r.shape
+		}
+	}
+
+	return ""
+}
+
+// icon returns the first configured library texture and its default atlas cell.
+func (th *theme) icon(key string, groupPath string) (string, [4]float64) {
+	tokens := strings.Split(strings.ToLower(key), "_")
+	groupPath = strings.ToLower(groupPath)
+
+	for i := range th.rules {
+		r := &th.rules[i]
+		if r.tex == "" {
+			continue
+		}
+		if _, ok := r.match(tokens, groupPath); ok {
+			return r.tex, r.uv
+		}
+	}
+
+	return "", defaultUV
+}
+
+// templateUV returns the atlas cell of a model, or def when no icon rule matches.
+// Without a library texture there is no atlas and the full cell is used.
+func (th *theme) templateUV(rel string, tex string, def [4]float64) [4]float64 {
+	if tex == "" {
+		return defaultUV
+	}
+	if len(th.icons) == 0 {
+		return def
+	}
+
+	rel = strings.ToLower(rel)
+	name := path.Base(rel)
+	for _, ic := range th.icons {
+		subject := name
+		if strings.Contains(ic.Pattern, "/") {
+			subject = rel
+		}
+		if ok, _ := path.Match(ic.Pattern, subject); ok {
+			return ic.UV
+		}
+	}
+
+	return def
+}
+
+// parseColor parses "#RRGGBB", "#AARRGGBB" or "r,g,b" into an ARGB int.
+func parseColor(s string) (int, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "#") {
+		hex := s[1:]
+		v, err := strconv.ParseUint(hex, 16, 32)
+		if err != nil {
+			return 0, fmt.Errorf("bad color %q", s)
+		}
+		switch len(hex) {
+		case 6:
+			return rgb(int32(v>>16), int32(v>>8), int32(v)), nil // #nosec G115 -- masked to 8 bits
+		case 8:
+			return int(int32(uint32(v))), nil // #nosec G115 -- ARGB bit pattern
+		default:
+			return 0, fmt.Errorf("bad color %q", s)
+		}
+	}
+
+	parts := strings.Split(s, ",")
+	if len(parts) != 3 {
+		return 0, fmt.Errorf("bad color %q (want #RRGGBB, #AARRGGBB or r,g,b)", s)
+	}
+	var c [3]int32
+	for i, p := range parts {
+		v, err := strconv.ParseUint(strings.TrimSpace(p), 10, 8)
+		if err != nil {
+			return 0, fmt.Errorf("bad color %q", s)
+		}
+		c[i] = int32(v)
+	}
+
+	return rgb(c[0], c[1], c[2]), nil
+}
+
+// loadTheme picks a named theme from the built-ins and an optional theme file.
+func loadTheme(file string, name string) (*theme, error) {
+	specs := make(map[string]ThemeSpec, len(builtinThemes))
+	fromFile := make(map[string]bool)
+	for k, v := range builtinThemes {
+		specs[k] = v
+	}
+
+	if file != "" {
+		data, err := os.ReadFile(file) // #nosec G304 -- path comes from CLI flag
+		if err != nil {
+			return nil, fmt.Errorf("read theme file: %w", err)
+		}
+		var tf ThemeFile
+		if err := json.Unmarshal(data, &tf); err != nil {
+			return nil, fmt.Errorf("parse theme file %s: %w", file, err)
+		}
+		for k, v := range tf.Themes {
+			specs[k] = v
+			fromFile[k] = true
+		}
+	}
+
+	spec, ok := specs[name]
+	if !ok {
+		known := make([]string, 0, len(specs))
+		for k := range specs {
+			known = append(known, k)
+		}
+		sort.Strings(known)
+		return nil, fmt.Errorf("unknown theme %q (available: %s)", name, strings.Join(known, ", "))
+	}
+
+	th, err := compileTheme(name, spec)
+	if err != nil {
+		return nil, err
+	}
+
+	// Shapes of user themes are explicit and override model-based shapes.
+	th.explicit = fromFile[name]
+	return th, nil
+}
+
+// dumpThemes writes the built-in themes as a theme file.
+func dumpThemes(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(ThemeFile{Themes: builtinThemes})
+}
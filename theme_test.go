@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestParseColor(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		in      string
+		wantHex string
+	}{
+		{"#1E1E1E", "0xFF1E1E1E"},
+		{"#801E1E1E", "0x801E1E1E"},
+		{"0, 128,255", "0xFF0080FF"},
+	}
+
+	for _, tc := range cases {
+		c, err := parseColor(tc.in)
+		if err != nil {
+			t.Fatalf("parseColor(%q): %v", tc.in, err)
+		}
+		if got := fmt.Sprintf("0x%08X", uint32(c)); got != tc.wantHex {
+			t.Fatalf("parseColor(%q)=%s want %s", tc.in, got, tc.wantHex)
+		}
+	}
+
+	for _, bad := range []string{"#12345", "1,2", "256,0,0", "red"} {
+		if _, err := parseColor(bad); err == nil {
+			t.Fatalf("parseColor(%q) should fail", bad)
+		}
+	}
+}
+
+func TestBuiltinThemeMatchesLegacyTable(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name    string
+		fill    int
+		outline int
+		shape   string
+		ok      bool
+	}{
+		{"dz_water_pond_river", rgb(34, 160, 170), outlineForKey("pond_river"), "", true},
+		{"dz_structures_plants", rgb(150, 150, 150), outlineForKey("plants"), "ellipse", true},
+		{"dz_rocks", rgb(120, 110, 100), defaultOutline, "ellipse", true},
+		{"foo_bar", 0, 0, "", false},
+	}
+
+	for _, tc := range cases {
+		fill, outline, ok := defaultTheme.colors(tc.name, "")
+		if ok != tc.ok || ok && (fill != tc.fill || outline != tc.outline) {
+			t.Fatalf("colors(%q)=%d,%d,%v want %d,%d,%v", tc.name, fill, outline, ok, tc.fill, tc.outline, tc.ok)
+		}
+		if got := defaultTheme.shape(tc.name, ""); got != tc.shape {
+			t.Fatalf("shape(%q)=%q want %q", tc.name, got, tc.shape)
+		}
+	}
+}
+
+func TestThemePatternRule(t *testing.T) {
+	t.Parallel()
+
+	th, err := compileTheme("test", ThemeSpec{Rules: []ThemeRule{
+		{Pattern: "mymod/*", Fill: "#FF0000", Outline: "#00FF00", Shape: "ellipse"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fill, outline, ok := th.colors("MyMod_Trees", "MyMod/Trees")
+	if !ok || fill != rgb(255, 0, 0) || outline != rgb(0, 255, 0) {
+		t.Fatalf("pattern colors=%d,%d", fill, outline)
+	}
+	if got := th.shape("MyMod_Trees", "MyMod/Trees"); got != "ellipse" {
+		t.Fatalf("pattern shape=%q want ellipse", got)
+	}
+	if got := th.shape("dz_trees", "dz/trees"); got != "" {
+		t.Fatalf("unmatched shape=%q want empty", got)
+	}
+	if _, _, ok := th.colors("dz_trees", "dz/trees"); ok {
+		t.Fatal("unmatched colors should need a fallback")
+	}
+}
+
+func TestThemeIcons(t *testing.T) {
+	t.Parallel()
+
+	th, err := compileTheme("test", ThemeSpec{
+		Rules: []ThemeRule{
+			{Tokens: []string{"structures"}, Tex: `mymod\icons.paa`, UV: &[4]float64{0, 0, 0.5, 0.5}},
+		},
+		Icons: []IconRule{
+			{Pattern: "barn*.p3d", UV: [4]float64{0.5, 0, 1, 0.5}},
+			{Pattern: "dz/structures/silo/*", UV: [4]float64{0, 0.5, 0.5, 1}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tex, uv := th.icon("dz_structures_farm", "dz/structures/farm")
+	if tex != `mymod\icons.paa` || uv != [4]float64{0, 0, 0.5, 0.5} {
+		t.Fatalf("icon=%q %v", tex, uv)
+	}
+	if tex, uv := th.icon("dz_plants", "dz/plants"); tex != "" || uv != defaultUV {
+		t.Fatalf("unmatched icon=%q %v", tex, uv)
+	}
+
+	cases := []struct {
+		rel  string
+		want [4]float64
+	}{
+		{"dz/structures/farm/Barn_Big.p3d", [4]float64{0.5, 0, 1, 0.5}},
+		{"dz/structures/silo/Silo.p3d", [4]float64{0, 0.5, 0.5, 1}},
+		{"dz/structures/farm/shed.p3d", uv},
+	}
+	for _, tc := range cases {
+		if got := th.templateUV(tc.rel, tex, uv); got != tc.want {
+			t.Fatalf("templateUV(%q)=%v want %v", tc.rel, got, tc.want)
+		}
+	}
+
+	// Icon rules need an atlas texture on the library.
+	if got := th.templateUV("dz/plants/Barn_Big.p3d", "", defaultUV); got != defaultUV {
+		t.Fatalf("templateUV without tex=%v want %v", got, defaultUV)
+	}
+
+	if _, err := compileTheme("bad", ThemeSpec{Icons: []IconRule{{Pattern: "*", UV: [4]float64{1, 0, 0, 1}}}}); err == nil {
+		t.Fatal("inverted uv should be rejected")
+	}
+}
@@ -0,0 +1,225 @@
+// Package tml writes TerrainBuilder template libraries (*.tml).
+package tml
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// DateFormat is the TerrainBuilder template <Date> format.
+const DateFormat = "01/02/06 15:04:05"
+
+// utf8BOM is the UTF-8 byte order mark.
+const utf8BOM = "\xEF\xBB\xBF"
+
+// Library is a template library written as a single tml file.
+type Library struct {
+	Name      string     // library name, also the file base name
+	Shape     string     // library shape: "ellipse" or "rectangle"
+	Tex       string     // icon texture, empty for none
+	Templates []Template // templates in file order
+	Fill      int        // default fill color (ARGB)
+	Outline   int        // default outline color (ARGB), -1 for none
+}
+
+// Template is a single library entry.
+type Template struct {
+	Name    string     // display name, unique across all loaded libraries
+	File    string     // model path relative to the game root, with '\'
+	Fill    int        // fill color (ARGB)
+	Outline int        // outline color (ARGB), -1 for none
+	Hash    int32      // model name hash, see Hash
+	UV      [4]float64 // icon texture cell: LLU, LLV, URU, URV
+}
+
+// Format controls the byte layout of written tml files.
+type Format struct {
+	EOL      string // line ending, "\n" or "\r\n"
+	Indent   string // one indentation level
+	Encoding string // encoding declared in the XML prolog, empty for none
+	BOM      bool   // write a UTF-8 byte order mark
+}
+
+// DefaultFormat is the classic layout: LF, tabs, no BOM.
+var DefaultFormat = Format{EOL: "\n", Indent: "\t"}
+
+// Hash hashes a p3d model name (without extension) like TerrainBuilder does.
+func Hash(s string) int32 {
+	var h int32
+	for i := 0; i < len(s); i++ {
+		c := int32(s[i])
+		h = c + (h << 6) + (h << 16) - h
+	}
+
+	return h
+}
+
+// Write streams a library as tml XML into w using format f.
+// The date is written to every template <Date>.
+func Write(w io.Writer, lib *Library, date time.Time, f Format) error {
+	out := bufio.NewWriterSize(w, 64<<10)
+	if f.BOM {
+		out.WriteString(utf8BOM)
+	}
+
+	// Templates are rendered with LF and tabs, other layouts are rewritten on the fly.
+	b := out
+	if f.EOL != "\n" || f.Indent != "\t" {
+		b = bufio.NewWriterSize(&layoutWriter{w: out, eol: f.EOL, indent: f.Indent, lineStart: true}, 64<<10)
+	}
+
+	now := date.Format(DateFormat)
+	writeLibraryHeader(b, f.Encoding, lib.Name, lib.Shape, lib.Fill, lib.Outline, lib.Tex)
+
+	for _, t := range lib.Templates {
+		writeTemplate(b, t.Name, t.File, now, t.Fill, t.Outline, t.Hash, t.UV)
+	}
+
+	writeLibraryFooter(b)
+	if err := b.Flush(); err != nil {
+		return err
+	}
+
+	return out.Flush()
+}
+
+// layoutWriter rewrites canonical LF/tab output into a Format layout.
+// Only tabs at the start of a line are treated as indentation.
+type layoutWriter struct {
+	w         *bufio.Writer // buffered destination
+	eol       string
+	indent    string
+	lineStart bool
+}
+
+// Write implements io.Writer.
+func (lw *layoutWriter) Write(p []byte) (int, error) {
+	for _, c := range p {
+		switch {
+		case c == '\n':
+			_, _ = lw.w.WriteString(lw.eol)
+			lw.lineStart = true
+		case c == '\t' && lw.lineStart:
+			_, _ = lw.w.WriteString(lw.indent)
+		default:
+			_ = lw.w.WriteByte(c)
+			lw.lineStart = false
+		}
+	}
+
+	return len(p), nil
+}
+
+// xmlEscapeText escapes text for XML.
+func xmlEscapeText(s string) string {
+	s = strings.ReplaceAll(s, "&", "&amp;")
+	s = strings.ReplaceAll(s, "<", "&lt;")
+	s = strings.ReplaceAll(s, ">", "&gt;")
+	return s
+}
+
+// xmlEscapeAttr escapes attributes for XML.
+func xmlEscapeAttr(s string) string {
+	s = xmlEscapeText(s)
+	s = strings.ReplaceAll(s, `"`, "&quot;")
+	return s
+}
+
+// writeLibraryHeader writes the library header.
+func writeLibraryHeader(b *bufio.Writer, encoding string, libraryName string, shape string, fill int, outline int, tex string) {
+	if encoding == "" {
+		b.WriteString(`<?xml version="1.0" ?>`)
+	} else {
+		b.WriteString(`<?xml version="1.0" encoding="`)
+		b.WriteString(xmlEscapeAttr(encoding))
+		b.WriteString(`" ?>`)
+	}
+	b.WriteString("\n")
+	b.WriteString(`<Library name="`)
+	b.WriteString(xmlEscapeAttr(libraryName))
+	b.WriteString(`" shape="`)
+	b.WriteString(xmlEscapeAttr(shape))
+	b.WriteString(`" default_fill="`)
+	fmt.Fprint(b, fill)
+	b.WriteString(`" default_outline="`)
+	fmt.Fprint(b, outline)
+	b.WriteString(`" tex="`)
+	if tex == "" {
+		b.WriteString("0")
+	} else {
+		b.WriteString(xmlEscapeAttr(tex))
+	}
+	b.WriteString(`">` + "\n")
+}
+
+// writeLibraryFooter writes the library footer.
+func writeLibraryFooter(b *bufio.Writer) {
+	b.WriteString(`</Library>`)
+	b.WriteString("\n")
+}
+
+// writeTemplate writes a template.
+func writeTemplate(b *bufio.Writer, name string, file string, date string, fill int, outline int, hash int32, uv [4]float64) {
+	b.WriteString("\t<Template>\n\t\t<Name>")
+	b.WriteString(xmlEscapeText(name))
+	b.WriteString("</Name>\n\t\t<File>")
+	b.WriteString(xmlEscapeText(file))
+	b.WriteString("</File>\n\t\t<Date>")
+	b.WriteString(date)
+	b.WriteString("</Date>\n\t\t<Archive></Archive>\n\t\t<Fill>")
+	fmt.Fprint(b, fill)
+	b.WriteString("</Fill>\n\t\t<Outline>")
+	fmt.Fprint(b, outline)
+	b.WriteString("</Outline>\n\t\t<Scale>1.000000</Scale>\n\t\t<Hash>")
+	b.WriteString(i32toa(hash))
+	b.WriteString("</Hash>\n\t\t<ScaleRandMin>0.000000</ScaleRandMin>\n\t\t<ScaleRandMax>0.000000</ScaleRandMax>\n")
+	b.WriteString("\t\t<YawRandMin>0.000000</YawRandMin>\n\t\t<YawRandMax>0.000000</YawRandMax>\n")
+	b.WriteString("\t\t<PitchRandMin>0.000000</PitchRandMin>\n\t\t<PitchRandMax>0.000000</PitchRandMax>\n")
+	b.WriteString("\t\t<RollRandMin>0.000000</RollRandMin>\n\t\t<RollRandMax>0.000000</RollRandMax>\n")
+	fmt.Fprintf(b, "\t\t<TexLLU>%.6f</TexLLU>\n\t\t<TexLLV>%.6f</TexLLV>\n", uv[0], uv[1])
+	fmt.Fprintf(b, "\t\t<TexURU>%.6f</TexURU>\n\t\t<TexURV>%.6f</TexURV>\n", uv[2], uv[3])
+	b.WriteString("\t\t<BBRadius>-1.000000</BBRadius>\n\t\t<BBHScale>1.000000</BBHScale>\n")
+	b.WriteString("\t\t<AutoCenter>0</AutoCenter>\n")
+	b.WriteString("\t\t<XShift>0.000000</XShift>\n\t\t<YShift>0.000000</YShift>\n")
+	b.WriteString("\t\t<ZShift>0.000000</ZShift>\n\t\t<Height>0.000000</Height>\n")
+	b.WriteString("\t\t<BoundingMin X=\"999.000000\" Y=\"999.000000\" Z=\"999.000000\" />\n")
+	b.WriteString("\t\t<BoundingMax X=\"-999.000000\" Y=\"-999.000000\" Z=\"-999.000000\" />\n")
+	b.WriteString("\t\t<BoundingCenter X=\"-999.000000\" Y=\"-999.000000\" Z=\"-999.000000\" />\n")
+	b.WriteString("\t\t<Placement></Placement>\n\t</Template>\n")
+}
+
+// i32toa converts an int32 to a string.
+func i32toa(x int32) string {
+	if x == 0 {
+		return "0"
+	}
+
+	// Convert negative int32 to positive uint32.
+	neg := x < 0
+	var u uint32
+	if neg {
+		u = uint32(^x) + 1
+	} else {
+		u = uint32(x)
+	}
+
+	// Convert uint32 to string.
+	var buf [11]byte
+	i := len(buf)
+	for u > 0 {
+		i--
+		buf[i] = byte('0' + (u % 10))
+		u /= 10
+	}
+
+	// Add negative sign if the original int32 was negative.
+	if neg {
+		i--
+		buf[i] = '-'
+	}
+
+	return string(buf[i:])
+}
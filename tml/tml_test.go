@@ -0,0 +1,109 @@
+package tml
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHash(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		in   string
+		want int32
+	}{
+		{"", 0},
+		{"a", 97},
+		{"ab", 6363201},
+	}
+
+	for _, tc := range cases {
+		got := Hash(tc.in)
+		if got != tc.want {
+			t.Fatalf("Hash(%q)=%d want %d", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestI32toa(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		in   int32
+		want string
+	}{
+		{0, "0"},
+		{1, "1"},
+		{-1, "-1"},
+		{123456, "123456"},
+	}
+
+	for _, tc := range cases {
+		got := i32toa(tc.in)
+		if got != tc.want {
+			t.Fatalf("i32toa(%d)=%q want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestWrite(t *testing.T) {
+	t.Parallel()
+
+	const fill = -16711165 // 0xFF010203
+	lib := &Library{
+		Name:    "dz_test",
+		Shape:   "ellipse",
+		Fill:    fill,
+		Outline: -1,
+		Templates: []Template{
+			{Name: "a&b", File: `dz\test\a&b.p3d`, Fill: fill, Outline: -1, Hash: 97, UV: [4]float64{0, 0, 1, 1}},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := Write(&buf, lib, time.Date(2025, 5, 24, 13, 4, 5, 0, time.UTC), DefaultFormat); err != nil {
+		t.Fatal(err)
+	}
+
+	out := buf.String()
+	for _, want := range []string{
+		`<Library name="dz_test" shape="ellipse" default_fill="-16711165" default_outline="-1" tex="0">`,
+		"<Name>a&amp;b</Name>",
+		`<File>dz\test\a&amp;b.p3d</File>`,
+		"<Date>05/24/25 13:04:05</Date>",
+		"<TexURV>1.000000</TexURV>",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("output misses %q:\n%s", want, out)
+		}
+	}
+	if !strings.HasSuffix(out, "</Library>\n") {
+		t.Fatal("output is not closed with </Library>")
+	}
+}
+
+func TestWriteWindowsLayout(t *testing.T) {
+	t.Parallel()
+
+	f := Format{EOL: "\r\n", Indent: "  ", Encoding: "UTF-8", BOM: true}
+
+	lib := &Library{Name: "dz_test", Shape: "rectangle", Templates: []Template{{Name: "a", File: `dz\a.p3d`}}}
+	var buf bytes.Buffer
+	if err := Write(&buf, lib, time.Unix(0, 0), f); err != nil {
+		t.Fatal(err)
+	}
+
+	out := buf.String()
+	if !strings.HasPrefix(out, utf8BOM+`<?xml version="1.0" encoding="UTF-8" ?>`+"\r\n") {
+		t.Fatalf("bad prolog: %q", out[:60])
+	}
+	if !strings.Contains(out, "\r\n  <Template>\r\n    <Name>a</Name>\r\n") {
+		t.Fatalf("bad indentation or line endings:\n%q", out)
+	}
+	if strings.Contains(out, "\t") || strings.Count(out, "\n") != strings.Count(out, "\r\n") {
+		t.Fatal("tabs or bare LF left in output")
+	}
+
+}
@@ -2,60 +2,46 @@ package main
 
 import "testing"
 
-func TestHashP3D(t *testing.T) {
+func TestUniqueDisplayName(t *testing.T) {
 	t.Parallel()
 
-	cases := []struct {
-		in   string
-		want int32
-	}{
-		{"", 0},
-		{"a", 97},
-		{"ab", 6363201},
+	used := map[string]struct{}{}
+	if got := uniqueDisplayName("house", "dz/structures/house.p3d", used, nil); got != "house" {
+		t.Fatalf("uniqueDisplayName base=%q got %q want %q", "house", got, "house")
 	}
 
-	for _, tc := range cases {
-		got := hashP3D(tc.in)
-		if got != tc.want {
-			t.Fatalf("hashP3D(%q)=%d want %d", tc.in, got, tc.want)
-		}
+	used = map[string]struct{}{
+		"house":       {},
+		"house_wreck": {},
+	}
+	if got := uniqueDisplayName("house", "dz/structures/wrecks/house.p3d", used, nil); got != "house_1" {
+		t.Fatalf("uniqueDisplayName duplicate got %q want %q", got, "house_1")
 	}
 }
 
-func TestI32toa(t *testing.T) {
+func TestParseTMLFormat(t *testing.T) {
 	t.Parallel()
 
-	cases := []struct {
-		in   int32
-		want string
-	}{
-		{0, "0"},
-		{1, "1"},
-		{-1, "-1"},
-		{123456, "123456"},
+	f, err := parseTMLFormat("crlf", "2", "UTF-8", true)
+	if err != nil {
+		t.Fatal(err)
 	}
-
-	for _, tc := range cases {
-		got := i32toa(tc.in)
-		if got != tc.want {
-			t.Fatalf("i32toa(%d)=%q want %q", tc.in, got, tc.want)
-		}
+	if f.EOL != "\r\n" || f.Indent != "  " || f.Encoding != "UTF-8" || !f.BOM {
+		t.Fatalf("unexpected format %+v", f)
 	}
-}
 
-func TestUniqueDisplayName(t *testing.T) {
-	t.Parallel()
-
-	used := map[string]struct{}{}
-	if got := uniqueDisplayName("house", "dz/structures/house.p3d", used); got != "house" {
-		t.Fatalf("uniqueDisplayName base=%q got %q want %q", "house", got, "house")
+	for _, bad := range [][2]string{{"cr", "tab"}, {"lf", "x"}, {"lf", "9"}} {
+		if _, err := parseTMLFormat(bad[0], bad[1], "", false); err == nil {
+			t.Fatalf("parseTMLFormat(%q, %q) should fail", bad[0], bad[1])
+		}
 	}
 
-	used = map[string]struct{}{
-		"house":       {},
-		"house_wreck": {},
+	if f, err := parseTMLFormat("", "", " utf8 ", false); err != nil || f.Encoding != "UTF-8" {
+		t.Fatalf("utf8 parsed as %+v, %v", f, err)
 	}
-	if got := uniqueDisplayName("house", "dz/structures/wrecks/house.p3d", used); got != "house_1" {
-		t.Fatalf("uniqueDisplayName duplicate got %q want %q", got, "house_1")
+	for _, bad := range []string{"windows-1251", "ISO-8859-1", "UTF-16"} {
+		if _, err := parseTMLFormat("", "", bad, false); err == nil {
+			t.Fatalf("encoding %q should be rejected", bad)
+		}
 	}
 }
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// dirID identifies a directory for cycle detection.
+type dirID struct {
+	info fs.FileInfo // device/inode (file index on Windows)
+	real string      // real path, used when os.SameFile can't tell
+}
+
+// same reports whether two IDs refer to the same directory.
+func (d dirID) same(o dirID) bool {
+	if d.info != nil && o.info != nil && os.SameFile(d.info, o.info) {
+		return true
+	}
+	return d.real != "" && d.real == o.real
+}
+
+// walkJob is a directory waiting to be read.
+type walkJob struct {
+	dir   string  // logical path
+	chain []dirID // the directory and its ancestors, only when following links
+	root  int     // index of the walked root
+	top   bool    // the root itself, not yet visited
+}
+
+// walkQueue is a LIFO of directories shared by the walk workers.
+type walkQueue struct {
+	err     error
+	cond    *sync.Cond
+	jobs    []walkJob
+	pending int // queued and running jobs
+	mu      sync.Mutex
+}
+
+// next pops a job, waiting while other workers may still add some.
+func (q *walkQueue) next() (walkJob, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for len(q.jobs) == 0 && q.pending > 0 && q.err == nil {
+		q.cond.Wait()
+	}
+	if q.err != nil || len(q.jobs) == 0 {
+		return walkJob{}, false
+	}
+
+	job := q.jobs[len(q.jobs)-1]
+	q.jobs = q.jobs[:len(q.jobs)-1]
+	return job, true
+}
+
+// done queues the subdirectories of a finished job.
+func (q *walkQueue) done(sub []walkJob, err error) {
+	q.mu.Lock()
+	if err != nil && q.err == nil {
+		q.err = err
+	}
+	q.jobs = append(q.jobs, sub...)
+	q.pending += len(sub) - 1
+	q.mu.Unlock()
+
+	q.cond.Broadcast()
+}
+
+// walker walks directory trees with a bounded pool of workers.
+type walker struct {
+	cache   *scanCache
+	onErr   func(path string, err error) error // nil skips unreadable entries
+	workers int
+	follow  bool // follow symlinks and junctions to directories
+}
+
+// walk visits all roots using the cache for directory listings. fn gets
+// the worker index and the root index; returning fs.SkipDir for a
+// directory skips it. Unreadable directories and broken links are passed
+// to onErr, which stops the walk by returning an error.
+// Entries are visited in no particular order, paths stay logical
+// (below the root) when links are followed.
+func (wk *walker) walk(roots []string, fn func(worker, root int, path string, dir bool) error) error {
+	q := &walkQueue{pending: len(roots)}
+	q.cond = sync.NewCond(&q.mu)
+	for i := len(roots) - 1; i >= 0; i-- {
+		job := walkJob{dir: roots[i], root: i, top: true}
+		if wk.follow {
+			job.chain = []dirID{wk.id(roots[i], "")}
+		}
+		q.jobs = append(q.jobs, job)
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < max(wk.workers, 1); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				job, ok := q.next()
+				if !ok {
+					return
+				}
+				q.done(wk.walkOne(job, func(path string, dir bool) error {
+					return fn(w, job.root, path, dir)
+				}))
+			}
+		}()
+	}
+	wg.Wait()
+
+	return q.err
+}
+
+// walkOne visits the entries of a directory and returns subdirectories to descend into.
+func (wk *walker) walkOne(job walkJob, fn func(path string, dir bool) error) ([]walkJob, error) {
+	if job.top {
+		if err := fn(job.dir, true); err != nil {
+			if errors.Is(err, fs.SkipDir) {
+				return nil, nil
+			}
+			return nil, err
+		}
+	}
+
+	l, err := wk.cache.readDir(job.dir)
+	if err != nil {
+		return nil, wk.fail(job.dir, err)
+	}
+
+	files := l.Files
+	var linkDirs []walkJob
+	if wk.follow {
+		// Resolve links: directories are walked below the link path,
+		// other targets are visited as files.
+		for _, name := range l.Links {
+			path := filepath.Join(job.dir, name)
+			st, err := os.Stat(path)
+			if err != nil {
+				if err := wk.fail(path, err); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			if !st.IsDir() {
+				files = append(files, name)
+				continue
+			}
+
+			id := dirID{info: st, real: realPath(path)}
+			if cycle(job.chain, id) {
+				slog.Warn("link cycle skipped", "path", path)
+				continue
+			}
+			linkDirs = append(linkDirs, walkJob{dir: path, root: job.root, chain: appendChain(job.chain, id)})
+		}
+	} else {
+		files = append(files[:len(files):len(files)], l.Links...)
+	}
+
+	for _, name := range files {
+		if err := fn(filepath.Join(job.dir, name), false); err != nil && !errors.Is(err, fs.SkipDir) {
+			return nil, err
+		}
+	}
+
+	sub := make([]walkJob, 0, len(l.Dirs)+len(linkDirs))
+	for i := len(l.Dirs) - 1; i >= 0; i-- {
+		path := filepath.Join(job.dir, l.Dirs[i])
+		next := walkJob{dir: path, root: job.root}
+		if wk.follow {
+			next.chain = appendChain(job.chain, wk.id(path, childReal(job.chain, l.Dirs[i])))
+		}
+		sub = append(sub, next)
+	}
+	sub = append(sub, linkDirs...)
+
+	// Visit directories before queueing them so fn can skip them.
+	out := sub[:0]
+	for _, next := range sub {
+		if err := fn(next.dir, true); err != nil {
+			if errors.Is(err, fs.SkipDir) {
+				continue
+			}
+			return nil, err
+		}
+		out = append(out, next)
+	}
+
+	return out, nil
+}
+
+// fail reports an unreadable entry.
+func (wk *walker) fail(path string, err error) error {
+	if wk.onErr == nil {
+		return nil
+	}
+	return wk.onErr(path, err)
+}
+
+// id returns the identity of a directory, resolved is computed when empty.
+func (wk *walker) id(path string, resolved string) dirID {
+	st, err := os.Stat(path)
+	if err != nil {
+		st = nil
+	}
+	if resolved == "" {
+		resolved = realPath(path)
+	}
+	return dirID{info: st, real: resolved}
+}
+
+// realPath resolves links in path, "" when it can't.
+func realPath(path string) string {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return ""
+	}
+	return filepath.Clean(resolved)
+}
+
+// childReal derives the real path of a plain subdirectory from its parent.
+func childReal(chain []dirID, name string) string {
+	if len(chain) == 0 || chain[len(chain)-1].real == "" {
+		return ""
+	}
+	return filepath.Join(chain[len(chain)-1].real, name)
+}
+
+// cycle reports whether a directory is already on the chain.
+func cycle(chain []dirID, id dirID) bool {
+	for _, c := range chain {
+		if c.same(id) {
+			return true
+		}
+	}
+	return false
+}
+
+// appendChain returns a new chain with id appended, leaving chain untouched.
+func appendChain(chain []dirID, id dirID) []dirID {
+	out := make([]dirID, len(chain), len(chain)+1)
+	copy(out, chain)
+	return append(out, id)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// symlinkTree creates root/a/m.p3d, root/a/loop -> root and root/b -> root/a.
+func symlinkTree(t *testing.T) string {
+	t.Helper()
+
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "a"), 0o750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "a", "m.p3d"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(root, filepath.Join(root, "a", "loop")); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+	if err := os.Symlink(filepath.Join(root, "a"), filepath.Join(root, "b")); err != nil {
+		t.Fatal(err)
+	}
+
+	return root
+}
+
+func TestWalkerFollowsLinksWithLogicalPaths(t *testing.T) {
+	t.Parallel()
+
+	root := symlinkTree(t)
+	got := walkWith(t, root, &walker{workers: 4, follow: true})
+	// Both loop links point back at the root and are skipped,
+	// the linked b keeps its own path.
+	want := []string{"a/m.p3d", "b/m.p3d"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+}
+
+func TestWalkerKeepsLinksAsFilesByDefault(t *testing.T) {
+	t.Parallel()
+
+	root := symlinkTree(t)
+	got := walkWith(t, root, &walker{workers: 2})
+	want := []string{"a/loop", "a/m.p3d", "b"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+}
+
+func TestWalkerReportsBrokenLinks(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	broken := filepath.Join(root, "gone")
+	if err := os.Symlink(filepath.Join(root, "missing"), broken); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+
+	var reported []string
+	wk := &walker{workers: 1, follow: true, onErr: func(path string, _ error) error {
+		reported = append(reported, path)
+		return nil
+	}}
+	if got := walkWith(t, root, wk); len(got) != 0 {
+		t.Fatalf("got %v want nothing", got)
+	}
+	if len(reported) != 1 || reported[0] != broken {
+		t.Fatalf("reported %v want %s", reported, broken)
+	}
+}
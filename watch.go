@@ -0,0 +1,261 @@
+package main
+
+import (
+	"hash/fnv"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// WatchCommand defines arguments of the watch subcommand.
+type WatchCommand struct {
+	Debounce     time.Duration `long:"debounce" default:"500ms" description:"Wait for this long without changes before regenerating"`
+	PollInterval time.Duration `long:"poll-interval" default:"2s" description:"Interval between scans when polling"`
+	Poll         bool          `long:"poll" description:"Poll the scan paths instead of using file system notifications"`
+}
+
+// changeWatcher reports changed paths under the watched roots.
+type changeWatcher interface {
+	events() <-chan string // changed paths, "" when unknown
+	stop() error
+}
+
+// watch regenerates libraries after models under the scan paths change,
+// until interrupted. Watchers apply the skip, ignore and filter rules of a
+// run, so only changes a run would see trigger one. Every run still rescans
+// all paths (cheap with the scan cache) and rebuilds all groups, so names
+// stay unique over all libraries, but only libraries that differ from the
+// previous run are rewritten.
+func (g *generator) watch(cmd *WatchCommand) error {
+	ws, err := g.newWatchScope()
+	if err != nil {
+		return err
+	}
+
+	var w changeWatcher
+	mode := "notify"
+	if !cmd.Poll {
+		nw, err := newNotifyWatcher(ws)
+		if err != nil {
+			slog.Warn("file system notifications unavailable, polling instead", "err", err)
+		} else {
+			w = nw
+		}
+	}
+	if w == nil {
+		w = newPollWatcher(ws, cmd.PollInterval)
+		mode = "poll"
+	}
+	defer func() { _ = w.stop() }()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sig)
+
+	for {
+		progressLine.setPhase("watch")
+		slog.Info("watching for changes", "mode", mode, "paths", len(ws.roots))
+		if !debounce(w.events(), sig, cmd.Debounce) {
+			return nil
+		}
+
+		stats, err := g.run(true)
+		if err != nil {
+			slog.Error("regeneration failed", "err", err)
+			continue
+		}
+		logRun(stats)
+	}
+}
+
+// watchScope limits watchers to the directories and models a run would
+// scan. It keeps its own copies of the skip and filter rules, as their
+// counts belong to the reports of a run.
+type watchScope struct {
+	g         *generator
+	filter    *fileFilter
+	skipRules []*skipRule
+	roots     []scanRoot
+}
+
+// newWatchScope creates a scope over the scan paths of all layers.
+func (g *generator) newWatchScope() (*watchScope, error) {
+	roots, err := g.roots()
+	if err != nil {
+		return nil, err
+	}
+
+	return &watchScope{g: g, filter: g.filter.clone(), skipRules: cloneSkipRules(g.skipRules), roots: roots}, nil
+}
+
+// scanner returns a scanner for a single walk of the scope.
+// Unreadable entries are skipped, the next run reports them.
+func (ws *watchScope) scanner() *scanner {
+	sc := ws.g.newScanner(&errorLog{policy: onErrorSkip})
+	sc.filter = ws.filter
+	sc.skipRules = ws.skipRules
+	sc.watch = true
+	return sc
+}
+
+// rootOf returns the scan path containing path.
+func (ws *watchScope) rootOf(path string) (scanRoot, bool) {
+	for _, r := range ws.roots {
+		if startsWithPathPrefix(path, r.path) {
+			return r, true
+		}
+	}
+
+	return scanRoot{}, false
+}
+
+// accepts reports whether a run would scan the directory or pick the model
+// at path, given that its parent directory is scanned.
+func (ws *watchScope) accepts(path string, dir bool) bool {
+	root, ok := ws.rootOf(path)
+	if !ok {
+		return false
+	}
+
+	var sh scanShard
+	if err := ws.scanner().visit(&sh, root, path, dir); err != nil {
+		return false
+	}
+	return dir || len(sh.hits) > 0
+}
+
+// walkDirs calls fn for dir and every directory below it that a run would scan.
+func (ws *watchScope) walkDirs(dir string, fn func(path string) error) error {
+	root, ok := ws.rootOf(dir)
+	if !ok {
+		return nil
+	}
+	root.start = dir
+
+	sc := ws.scanner()
+	sc.onDir = fn
+	_, err := sc.scan([]scanRoot{root})
+	return err
+}
+
+// logRun logs the result of a run in watch mode.
+func logRun(stats runStats) {
+	args := []any{"p3d", stats.models, "groups", stats.groups}
+	if sum := stats.output; sum != nil {
+		args = append(args, "created", sum.Created, "updated", sum.Updated,
+			"unchanged", sum.Unchanged, "removed", sum.Removed, "stale", len(sum.Stale))
+	}
+	if stats.errors > 0 {
+		args = append(args, "errors", stats.errors)
+	}
+	slog.Info("libraries generated", args...)
+}
+
+// debounce waits for a change and then until no change arrives for delay.
+// It returns false when stop fires or events is closed first.
+func debounce(events <-chan string, stop <-chan os.Signal, delay time.Duration) bool {
+	select {
+	case path, ok := <-events:
+		if !ok {
+			return false
+		}
+		slog.Debug("change", "path", path)
+	case <-stop:
+		return false
+	}
+
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	for {
+		select {
+		case path, ok := <-events:
+			if !ok {
+				return false
+			}
+			slog.Debug("change", "path", path)
+			t.Reset(delay)
+		case <-t.C:
+			return true
+		case <-stop:
+			return false
+		}
+	}
+}
+
+// pollWatcher reports changes by comparing snapshots of the models.
+type pollWatcher struct {
+	ws   *watchScope
+	ch   chan string
+	done chan struct{}
+}
+
+// newPollWatcher starts polling the scope every interval.
+func newPollWatcher(ws *watchScope, interval time.Duration) *pollWatcher {
+	w := &pollWatcher{ws: ws, ch: make(chan string, 1), done: make(chan struct{})}
+
+	go func() {
+		defer close(w.ch)
+		last := w.snapshot()
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				if cur := w.snapshot(); cur != last {
+					last = cur
+					select {
+					case w.ch <- "":
+					default: // a change is already pending
+					}
+				}
+			case <-w.done:
+				return
+			}
+		}
+	}()
+
+	return w
+}
+
+// events returns the change channel.
+func (w *pollWatcher) events() <-chan string {
+	return w.ch
+}
+
+// stop ends polling.
+func (w *pollWatcher) stop() error {
+	close(w.done)
+	return nil
+}
+
+// snapshot sums hashes of the path, size and mtime of every model a run
+// would pick, so adding, removing or changing one alters the result.
+// Directory listings come from the scan cache while their mtime is
+// unchanged, so a poll lists only changed directories but still stats
+// every directory and picked model.
+func (w *pollWatcher) snapshot() uint64 {
+	hits, err := w.ws.scanner().scan(w.ws.roots)
+	if err != nil {
+		// A changed snapshot lets the next run report the error.
+		slog.Debug("poll failed", "err", err)
+		return 0
+	}
+
+	var sum uint64
+	for _, h := range hits {
+		path := filepath.Join(w.ws.g.layers[h.layer], filepath.FromSlash(h.rel))
+		st, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+
+		f := fnv.New64a()
+		_, _ = f.Write([]byte(path + "\x00" + strconv.FormatInt(st.Size(), 10) + "\x00" + strconv.FormatInt(st.ModTime().UnixNano(), 10)))
+		sum += f.Sum64()
+	}
+	return sum
+}
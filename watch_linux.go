@@ -0,0 +1,165 @@
+//go:build linux
+
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// inotifyMask selects events that can change the scan result.
+const inotifyMask = unix.IN_CREATE | unix.IN_DELETE | unix.IN_CLOSE_WRITE |
+	unix.IN_MOVED_FROM | unix.IN_MOVED_TO | unix.IN_DELETE_SELF | unix.IN_MOVE_SELF
+
+// inotifyWatcher reports changes under directory trees using inotify.
+type inotifyWatcher struct {
+	ws   *watchScope
+	file *os.File
+	ch   chan string
+	dirs map[int32]string // watch descriptor to directory
+	fd   int
+	mu   sync.Mutex
+}
+
+// newNotifyWatcher watches every directory of the scope a run would scan.
+func newNotifyWatcher(ws *watchScope) (changeWatcher, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("inotify: %w", err)
+	}
+
+	// A non-blocking descriptor goes through the runtime poller,
+	// so closing the file interrupts a pending read.
+	w := &inotifyWatcher{
+		ws:   ws,
+		file: os.NewFile(uintptr(fd), "inotify"),
+		ch:   make(chan string, 64),
+		dirs: make(map[int32]string),
+		fd:   fd,
+	}
+	for _, root := range ws.roots {
+		if err := w.addTree(root.path); err != nil {
+			_ = w.file.Close()
+			return nil, err
+		}
+	}
+
+	go w.loop()
+	return w, nil
+}
+
+// events returns the change channel.
+func (w *inotifyWatcher) events() <-chan string {
+	return w.ch
+}
+
+// stop closes the inotify descriptor.
+func (w *inotifyWatcher) stop() error {
+	return w.file.Close()
+}
+
+// addTree adds watches for dir and the directories below it,
+// leaving out skipped and ignored ones.
+func (w *inotifyWatcher) addTree(dir string) error {
+	return w.ws.walkDirs(dir, func(path string) error {
+		wd, err := unix.InotifyAddWatch(w.fd, path, inotifyMask)
+		switch {
+		case errors.Is(err, unix.ENOSPC):
+			return errors.New("inotify watch limit reached (raise fs.inotify.max_user_watches or use --poll)")
+		case err != nil:
+			// Gone or unreadable, the next scan reports it.
+			slog.Debug("watch skipped", "path", path, "err", err)
+			return fs.SkipDir
+		}
+
+		w.mu.Lock()
+		w.dirs[int32(wd)] = path // #nosec G115 -- watch descriptors are small
+		w.mu.Unlock()
+		return nil
+	})
+}
+
+// loop reads events until the descriptor is closed.
+func (w *inotifyWatcher) loop() {
+	defer close(w.ch)
+
+	buf := make([]byte, 64<<10)
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			if !errors.Is(err, os.ErrClosed) {
+				slog.Error("watch error", "err", err)
+			}
+			return
+		}
+
+		for off := 0; off+unix.SizeofInotifyEvent <= n; {
+			wd := int32(binary.NativeEndian.Uint32(buf[off:])) // #nosec G115 -- kernel ABI
+			mask := binary.NativeEndian.Uint32(buf[off+4:])
+			size := int(binary.NativeEndian.Uint32(buf[off+12:]))
+			off += unix.SizeofInotifyEvent
+
+			name := ""
+			if size > 0 && off+size <= n {
+				name = unix.ByteSliceToString(buf[off : off+size])
+			}
+			off += size
+
+			w.handle(wd, mask, name)
+		}
+	}
+}
+
+// handle watches new directories and forwards relevant changes.
+func (w *inotifyWatcher) handle(wd int32, mask uint32, name string) {
+	if mask&unix.IN_Q_OVERFLOW != 0 {
+		w.send("")
+		return
+	}
+
+	w.mu.Lock()
+	dir, ok := w.dirs[wd]
+	if mask&unix.IN_IGNORED != 0 {
+		delete(w.dirs, wd)
+	}
+	w.mu.Unlock()
+	if !ok || mask&inotifyMask == 0 {
+		return
+	}
+
+	path := filepath.Join(dir, name)
+	isDir := mask&unix.IN_ISDIR != 0
+	if !isDir && name != "" && mask&(unix.IN_CREATE|unix.IN_MOVED_TO) != 0 && w.ws.g.opt.FollowLinks {
+		// A new link may lead to a directory worth watching.
+		if st, err := os.Lstat(path); err == nil && st.Mode()&fs.ModeSymlink != 0 {
+			isDir = true
+		}
+	}
+	if name != "" && name != ignoreFileName && !w.ws.accepts(path, isDir) {
+		return
+	}
+	if isDir && mask&(unix.IN_CREATE|unix.IN_MOVED_TO) != 0 {
+		// Files created before the watch was added are found by the next scan.
+		if err := w.addTree(path); err != nil {
+			slog.Warn("new directory not watched", "path", path, "err", err)
+		}
+	}
+
+	w.send(path)
+}
+
+// send forwards a change without blocking the reader.
+func (w *inotifyWatcher) send(path string) {
+	select {
+	case w.ch <- path:
+	default: // changes are already pending
+	}
+}
@@ -0,0 +1,65 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// nextEvent waits for a change or fails.
+func nextEvent(t *testing.T, w changeWatcher) string {
+	t.Helper()
+
+	select {
+	case path := <-w.events():
+		return path
+	case <-time.After(5 * time.Second):
+		t.Fatal("no change reported")
+		return ""
+	}
+}
+
+func TestInotifyWatchesNewDirectories(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	ws := testWatchScope(t, root, "data")
+	writeTree(t, root, "dz/data/old.p3d", "")
+	w, err := newNotifyWatcher(ws)
+	if err != nil {
+		t.Skipf("inotify unavailable: %v", err)
+	}
+	defer func() { _ = w.stop() }()
+
+	sub := filepath.Join(root, "dz", "sub")
+	if err := os.Mkdir(sub, 0o750); err != nil {
+		t.Fatal(err)
+	}
+	if got := nextEvent(t, w); got != sub {
+		t.Fatalf("got %q want %q", got, sub)
+	}
+
+	// Skipped trees and unrelated files are ignored, models in the new
+	// directory are not.
+	writeTree(t, root, "dz/data/new.p3d", "")
+	writeTree(t, root, "dz/data/more/x.p3d", "")
+	writeTree(t, root, "dz/sub/notes.txt", "")
+	model := filepath.Join(sub, "m.p3d")
+	writeTree(t, root, "dz/sub/m.p3d", "")
+	if got := nextEvent(t, w); got != model {
+		t.Fatalf("got %q want %q", got, model)
+	}
+
+	// The skipped tree holds no watches.
+	iw := w.(*inotifyWatcher)
+	iw.mu.Lock()
+	defer iw.mu.Unlock()
+	for _, dir := range iw.dirs {
+		if filepath.Base(dir) == "data" {
+			t.Fatalf("skipped directory %s is watched", dir)
+		}
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"runtime"
+)
+
+// newNotifyWatcher is not implemented here, the caller polls instead.
+func newNotifyWatcher(_ *watchScope) (changeWatcher, error) {
+	return nil, errors.New("not supported on " + runtime.GOOS)
+}
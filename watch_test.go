@@ -0,0 +1,164 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/woozymasta/tml-gen/tml"
+)
+
+func TestDebounceWaitsForQuiet(t *testing.T) {
+	t.Parallel()
+
+	events := make(chan string, 8)
+	stop := make(chan os.Signal)
+	for range 3 {
+		events <- "a.p3d"
+	}
+
+	start := time.Now()
+	if !debounce(events, stop, 50*time.Millisecond) {
+		t.Fatal("debounce stopped without a signal")
+	}
+	if len(events) != 0 {
+		t.Fatalf("%d events left", len(events))
+	}
+	if d := time.Since(start); d < 50*time.Millisecond {
+		t.Fatalf("returned after %s", d)
+	}
+}
+
+func TestDebounceStops(t *testing.T) {
+	t.Parallel()
+
+	events := make(chan string)
+	stop := make(chan os.Signal, 1)
+	stop <- os.Interrupt
+	if debounce(events, stop, time.Millisecond) {
+		t.Fatal("debounce ignored the stop signal")
+	}
+
+	close(events)
+	if debounce(events, make(chan os.Signal), time.Millisecond) {
+		t.Fatal("debounce ignored closed events")
+	}
+}
+
+// testWatchScope returns a watch scope over root/dz with skip rules.
+func testWatchScope(t *testing.T, root string, skip ...string) *watchScope {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Join(root, "dz"), 0o750); err != nil {
+		t.Fatal(err)
+	}
+	rules, err := buildSkipRules(root, skip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := &generator{
+		opt: &Options{GameRoot: root}, filter: &fileFilter{}, skipRules: rules,
+		scanRoots: []string{filepath.Join(root, "dz")}, layers: []string{root}, jobs: 2,
+	}
+	ws, err := g.newWatchScope()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ws
+}
+
+// writeTree writes a file below root, creating its directories.
+func writeTree(t *testing.T, root, rel, content string) {
+	t.Helper()
+
+	path := filepath.Join(root, filepath.FromSlash(rel))
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPollSnapshotTracksModels(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	w := &pollWatcher{ws: testWatchScope(t, root, "data")}
+	writeTree(t, root, "dz/a.p3d", "a")
+	writeTree(t, root, "dz/.tmlignore", "wip/\n")
+	base := w.snapshot()
+
+	// Files a run would not pick leave the snapshot alone.
+	for _, rel := range []string{"dz/notes.txt", "dz/data/x.p3d", "dz/wip/m.p3d", "dz/sub/readme.md"} {
+		writeTree(t, root, rel, rel)
+		if w.snapshot() != base {
+			t.Fatalf("%s changed the snapshot", rel)
+		}
+	}
+
+	writeTree(t, root, "dz/sub/b.p3d", "b")
+	next := w.snapshot()
+	if next == base {
+		t.Fatal("new model not detected")
+	}
+	writeTree(t, root, "dz/a.p3d", "changed")
+	if w.snapshot() == next {
+		t.Fatal("changed model not detected")
+	}
+}
+
+func TestWatchScopeAccepts(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	ws := testWatchScope(t, root, "data")
+	ws.filter = &fileFilter{}
+	if err := ws.filter.add("**/*_ruin*.p3d", true); err != nil {
+		t.Fatal(err)
+	}
+	writeTree(t, root, "dz/.tmlignore", "proxy/\n")
+
+	cases := []struct {
+		rel  string
+		dir  bool
+		want bool
+	}{
+		{"dz/a", true, true},
+		{"dz/data", true, false},
+		{"dz/a/proxy", true, false},
+		{"dz/a/house.p3d", false, true},
+		{"dz/a/house_ruin.p3d", false, false},
+		{"dz/a/notes.txt", false, false},
+		{"other/house.p3d", false, false},
+	}
+	for _, tc := range cases {
+		if got := ws.accepts(filepath.Join(root, filepath.FromSlash(tc.rel)), tc.dir); got != tc.want {
+			t.Fatalf("accepts(%q) = %v want %v", tc.rel, got, tc.want)
+		}
+	}
+}
+
+func TestGeneratorChangedLibraries(t *testing.T) {
+	t.Parallel()
+
+	lib := Library{Library: tml.Library{Name: "Lib", Templates: []tml.Template{{Name: "a", File: `x\a.p3d`}}}}
+	g := &generator{}
+	if !g.changed(&lib) {
+		t.Fatal("first run must write every library")
+	}
+
+	g.prev = map[string]Library{"lib": lib}
+	same := lib
+	same.Templates = []tml.Template{{Name: "a", File: `x\a.p3d`}}
+	if g.changed(&same) {
+		t.Fatal("equal library reported as changed")
+	}
+
+	renamed := same
+	renamed.Templates = []tml.Template{{Name: "a_1", File: `x\a.p3d`}}
+	if !g.changed(&renamed) {
+		t.Fatal("renamed template not reported")
+	}
+}